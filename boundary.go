@@ -0,0 +1,169 @@
+package trietest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BoundaryCase is a trie with a node, other than the root, whose encoding is within a few
+// bytes of 32, which is where a node stops being inlined in its parent and is referenced by
+// hash instead.
+type BoundaryCase struct {
+	Keys   [][]byte
+	Values [][]byte
+	Shape  string // the types of the nodes from Depth to the boundary node
+	Depth  int    // the depth of the first node of Shape in nibbles
+	Size   int    // the length of the encoding of the boundary node
+}
+
+// BoundaryCases returns tries of several shapes, with the boundary node at every depth from
+// 1 to 64 nibbles: a leaf; a branch of leaves, with and without a value; and an extension to
+// a branch of leaves, which nests an inlined branch inside an inlined extension. The node at
+// or below that depth whose encoding is closest to 32 bytes must be within slack bytes of it.
+func BoundaryCases(slack int) []BoundaryCase {
+	var bcs []BoundaryCase
+	seen := map[string]struct{}{}
+	add := func(p []byte, nkv []nodeKeyValue) {
+		bc, ok := boundaryCase(p, nkv, slack)
+		if !ok {
+			return
+		}
+
+		id := fmt.Sprintf("%s:%d:%d:%d", bc.Shape, bc.Depth, bc.Size, len(bc.Keys))
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			bcs = append(bcs, bc)
+		}
+	}
+
+	for depth := 1; depth <= 64; depth += 1 {
+		p := bytes.Repeat([]byte{0x01}, depth)
+
+		for r := depth % 2; r <= 40; r += 2 {
+			for vl := 1; vl <= 40; vl += 1 {
+				add(p, []nodeKeyValue{boundaryLeaf(p, nil, r, vl)})
+			}
+		}
+
+		for c := 2; c <= 16; c += 1 {
+			for r := (depth + 1) % 2; r <= 5; r += 2 {
+				for vl := 1; vl <= 16; vl += 1 {
+					var nkv []nodeKeyValue
+					if depth%2 == 0 {
+						nkv = append(nkv, boundaryLeaf(p, nil, 0, vl))
+					}
+					for idx := 0; idx < c; idx += 1 {
+						nkv = append(nkv, boundaryLeaf(p, []byte{byte(idx)}, r, vl))
+					}
+					add(p, nkv)
+					if depth%2 == 0 {
+						add(p, nkv[1:])
+					}
+				}
+			}
+		}
+
+		for e := 1; e <= 16; e += 1 {
+			for r := (depth + e + 1) % 2; r <= 3; r += 2 {
+				for vl := 1; vl <= 8; vl += 1 {
+					ext := bytes.Repeat([]byte{0x02}, e)
+					add(p,
+						[]nodeKeyValue{
+							boundaryLeaf(p, append(append([]byte(nil), ext...), 0x00), r, vl),
+							boundaryLeaf(p, append(append([]byte(nil), ext...), 0x0F), r, vl),
+						})
+				}
+			}
+		}
+	}
+
+	return bcs
+}
+
+// boundaryLeaf returns a key/value pair whose path is prefix, then path, then r nibbles, with
+// a value of vl bytes.
+func boundaryLeaf(prefix, path []byte, r, vl int) nodeKeyValue {
+	return nodeKeyValue{
+		path: joinPath(joinPath(prefix, path), bytes.Repeat([]byte{0x03}, r)),
+		val:  bytes.Repeat([]byte{0xAA}, vl),
+	}
+}
+
+// boundaryCase finds the node at or below prefix, which all of the keys in nkv start with,
+// which is closest to the boundary. It then adds a sibling key diverging from prefix at each
+// of its nibbles, so that there is a branch at every depth above the nodes at prefix.
+func boundaryCase(prefix []byte, nkv []nodeKeyValue, slack int) (BoundaryCase, bool) {
+	sortNodeKeyValues(nkv)
+
+	bc := BoundaryCase{
+		Depth: len(prefix),
+	}
+	best := -1
+	var walk func(n node, shape []string)
+	walk = func(n node, shape []string) {
+		var name string
+		switch n.(type) {
+		case *branchNode:
+			name = "branch"
+		case *extensionNode:
+			name = "extension"
+		case *leafNode:
+			name = "leaf"
+		default:
+			return
+		}
+		shape = append(shape, name)
+
+		size := len(encodeNode(n))
+		d := size - 32
+		if d < 0 {
+			d = -d
+		}
+		if d <= slack && (best < 0 || d < best) {
+			best = d
+			bc.Shape = strings.Join(shape, "/")
+			bc.Size = size
+		}
+
+		switch n := n.(type) {
+		case *branchNode:
+			for _, child := range n.children {
+				walk(child, shape)
+			}
+		case *extensionNode:
+			walk(n.next, shape)
+		}
+	}
+	walk(buildNode(nkv, len(prefix)), nil)
+	if best < 0 {
+		return bc, false
+	}
+
+	for i := range prefix {
+		path := joinPath(prefix[:i], []byte{0x0F})
+		if len(path)%2 == 1 {
+			path = append(path, 0x0F)
+		}
+		nkv = append(nkv,
+			nodeKeyValue{
+				path: path,
+				val:  []byte{0xFF},
+			})
+	}
+	sortNodeKeyValues(nkv)
+
+	for _, kv := range nkv {
+		bc.Keys = append(bc.Keys, nibblesToKey(kv.path))
+		bc.Values = append(bc.Values, kv.val)
+	}
+	return bc, true
+}
+
+func sortNodeKeyValues(nkv []nodeKeyValue) {
+	sort.Slice(nkv,
+		func(i, j int) bool {
+			return bytes.Compare(nkv[i].path, nkv[j].path) < 0
+		})
+}
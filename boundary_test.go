@@ -0,0 +1,41 @@
+package trietest_test
+
+import (
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+func TestBoundary(t *testing.T) {
+	suiteBoundary(t, testAdapters(t, 0))
+}
+
+func suiteBoundary(t *testing.T, tas []trietest.Adapter) {
+	bcs := trietest.BoundaryCases(2)
+
+	shapes := map[string]int{}
+	for _, bc := range bcs {
+		shapes[bc.Shape] += 1
+	}
+	t.Logf("%d boundary cases with %d shapes", len(bcs), len(shapes))
+
+	for _, bc := range bcs {
+		tries := testTries(tas)
+		for i, nt := range tries {
+			for j := range bc.Keys {
+				testPutTrie(t, nt.Name, nt.Trie, bc.Keys[j], bc.Values[j])
+			}
+
+			if i > 0 {
+				testHashTries(t, tries[0], nt)
+			}
+		}
+		testSerializeTries(t, tries)
+
+		if t.Failed() {
+			t.Logf("%s at depth %d with %d bytes: %d keys", bc.Shape, bc.Depth, bc.Size,
+				len(bc.Keys))
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Command trietest-decode decodes encoded trie nodes, such as the output of Serialize, and
+// pretty prints them. Each argument, or each line of stdin if there are none, is a node in
+// hex:
+//
+//	go run ./cmd/trietest-decode -format json c9842000123483012345
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/leftmike/trietest"
+)
+
+var format = flag.String("format", "text", "format of the output: text or json")
+
+func decode(s string) error {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil {
+		return err
+	}
+	n, err := trietest.DecodeNode(b, nil)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		fmt.Print(n)
+	case "json":
+		b, err = json.MarshalIndent(n, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	default:
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		scan := bufio.NewScanner(os.Stdin)
+		scan.Buffer(nil, 1<<24)
+		for scan.Scan() {
+			if strings.TrimSpace(scan.Text()) != "" {
+				args = append(args, scan.Text())
+			}
+		}
+		if err := scan.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "trietest-decode: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	for _, arg := range args {
+		err := decode(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "trietest-decode: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Command trietest-report reads the output of go test -json for TestConformance from stdin
+// and writes a conformance report of adapters by suites to stdout:
+//
+//	go test -json -run TestConformance -trietest.conformance -short | go run ./cmd/trietest-report
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/leftmike/trietest"
+)
+
+var (
+	format = flag.String("format", "markdown", "format of the report: markdown or json")
+	test   = flag.String("test", "TestConformance", "name of the test with the suites as subtests")
+)
+
+func main() {
+	flag.Parse()
+
+	rpt, err := trietest.ReadTestEvents(os.Stdin, *test)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "trietest-report: %s\n", err)
+		os.Exit(1)
+	}
+	if len(rpt.Rows) == 0 {
+		fmt.Fprintf(os.Stderr, "trietest-report: no results for %s; was -trietest.conformance set?\n",
+			*test)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "markdown":
+		err = rpt.WriteMarkdown(os.Stdout)
+	case "json":
+		err = rpt.WriteJSON(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown format: %s", *format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "trietest-report: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,217 @@
+package trietest_test
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+var conformanceFlag = flag.Bool("trietest.conformance", false,
+	"run TestConformance, which runs every suite against each adapter for a conformance report")
+
+// conformanceSuites are the columns of the conformance report; a suite is unsupported by an
+// adapter without caps. Each test of a suite is passed the adapters to compare, the reference
+// first.
+var conformanceSuites = []struct {
+	name  string
+	caps  trietest.Capabilities
+	tests []conformanceTest
+}{
+	{
+		name: "basic",
+		caps: trietest.CapRandomAccess,
+		tests: []conformanceTest{
+			{"TestBasic", suiteBasic},
+			{"TestCapabilities", suiteCapabilities},
+		},
+	},
+	{
+		name: "iterate",
+		caps: trietest.CapIterate | trietest.CapRandomAccess,
+		tests: []conformanceTest{
+			{"TestIterate", suiteIterate},
+		},
+	},
+	{
+		name: "edge",
+		caps: trietest.CapRandomAccess,
+		tests: []conformanceTest{
+			{"TestEdge", suiteEdge},
+			{"TestBoundary", suiteBoundary},
+		},
+	},
+	{
+		name: "random",
+		caps: trietest.CapRandomAccess,
+		tests: []conformanceTest{
+			{"TestRandomGetPut", suiteRandomGetPut},
+			{"TestRandomUpdate", suiteRandomUpdate},
+		},
+	},
+	{
+		name: "delete",
+		caps: trietest.CapDelete | trietest.CapRandomAccess,
+		tests: []conformanceTest{
+			{"TestRandomDeleteGetPut", suiteRandomDeleteGetPut},
+			{"TestRandom", suiteRandom},
+			{"TestInsertOrder", suiteInsertOrder},
+		},
+	},
+	{
+		name: "serialize",
+		caps: trietest.CapSerialize | trietest.CapRandomAccess,
+		tests: []conformanceTest{
+			{"TestDeserialize", suiteDeserialize},
+		},
+	},
+	{
+		name: "proof",
+		caps: trietest.CapProve | trietest.CapRandomAccess,
+		tests: []conformanceTest{
+			{"TestProve", suiteProve},
+			{"TestRangeProof", suiteRangeProof},
+		},
+	},
+	{
+		name: "sorted",
+		tests: []conformanceTest{
+			{"TestSorted", suiteSorted},
+		},
+	},
+}
+
+type conformanceTest struct {
+	name string
+	test func(t *testing.T, tas []trietest.Adapter)
+}
+
+// TestConformance runs each suite against each adapter selected by -trietest.adapters, as
+// subtests named TestConformance/<adapter>/<suite>/<test>. The first adapter with the
+// capabilities of a suite is the reference that the others are compared against; the suite
+// runs the reference by itself, so it also runs a subtest named trietest.ReferenceTest to mark
+// it in the report. To generate a report:
+//
+//	go test -json -run TestConformance -trietest.conformance -short | go run ./cmd/trietest-report
+func TestConformance(t *testing.T) {
+	if !*conformanceFlag {
+		t.Skip("-trietest.conformance not set")
+	}
+
+	tas := selectAdapters(t, 0)
+	for _, ta := range tas {
+		t.Run(ta.Name, func(t *testing.T) {
+			caps := ta.New().Capabilities()
+			for _, cs := range conformanceSuites {
+				t.Run(cs.name, func(t *testing.T) {
+					if !caps.Has(cs.caps) {
+						t.Skipf("unsupported: %s does not support %s", ta.Name, cs.caps&^caps)
+					}
+
+					sas := []trietest.Adapter{ta}
+					for _, ref := range tas {
+						if ref.New().Capabilities().Has(cs.caps) {
+							if ref.Name != ta.Name {
+								sas = []trietest.Adapter{ref, ta}
+							}
+							break
+						}
+					}
+					if len(sas) == 1 {
+						t.Run(trietest.ReferenceTest, func(t *testing.T) {
+							t.Logf("%s is the reference for %s", ta.Name, cs.name)
+						})
+					}
+
+					for _, ct := range cs.tests {
+						t.Run(ct.name,
+							func(t *testing.T) {
+								ct.test(t, sas)
+							})
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestReadTestEvents(t *testing.T) {
+	events := `
+{"Action":"run","Test":"TestConformance/a/basic"}
+{"Action":"run","Test":"TestConformance/a/basic/reference"}
+{"Action":"pass","Test":"TestConformance/a/basic/reference"}
+{"Action":"run","Test":"TestConformance/a/basic/TestBasic"}
+{"Action":"pass","Test":"TestConformance/a/basic/TestBasic"}
+{"Action":"run","Test":"TestConformance/a/basic/TestIterate"}
+{"Action":"skip","Test":"TestConformance/a/basic/TestIterate"}
+{"Action":"pass","Test":"TestConformance/a/basic"}
+{"Action":"run","Test":"TestConformance/a/delete"}
+{"Action":"run","Test":"TestConformance/a/delete/TestRandom"}
+{"Action":"output","Test":"TestConformance/a/delete/TestRandom","Output":"    trie_test.go:10: first\n"}
+{"Action":"output","Test":"TestConformance/a/delete/TestRandom","Output":"    trie_test.go:20: second\n"}
+{"Action":"fail","Test":"TestConformance/a/delete/TestRandom"}
+{"Action":"run","Test":"TestConformance/a/delete/TestInsertOrder"}
+{"Action":"pass","Test":"TestConformance/a/delete/TestInsertOrder"}
+{"Action":"fail","Test":"TestConformance/a/delete"}
+{"Action":"run","Test":"TestConformance/b/basic"}
+{"Action":"run","Test":"TestConformance/b/basic/TestBasic"}
+{"Action":"pass","Test":"TestConformance/b/basic/TestBasic"}
+{"Action":"pass","Test":"TestConformance/b/basic"}
+{"Action":"run","Test":"TestConformance/b/delete"}
+{"Action":"output","Test":"TestConformance/b/delete","Output":"    conformance_test.go:70: unsupported\n"}
+{"Action":"skip","Test":"TestConformance/b/delete"}
+{"Action":"run","Test":"TestConformance/b/edge"}
+{"Action":"run","Test":"TestConformance/b/edge/TestEdge"}
+{"Action":"skip","Test":"TestConformance/b/edge/TestEdge"}
+{"Action":"pass","Test":"TestConformance/b/edge"}
+{"Action":"pass","Test":"TestOther/a/basic/TestBasic"}
+`
+	rpt, err := trietest.ReadTestEvents(strings.NewReader(events), "TestConformance")
+	if err != nil {
+		t.Fatalf("ReadTestEvents() failed with %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = rpt.WriteMarkdown(&buf)
+	if err != nil {
+		t.Fatalf("WriteMarkdown() failed with %s", err)
+	}
+	want := `| adapter | basic | delete | edge | pass | fail | unsupported | skipped |
+|---|---|---|---|---|---|---|---|
+| a | pass (1, 1 skipped, reference) | fail (1/2) | | 1 | 1 | 0 | 0 |
+| b | pass (1) | unsupported | skipped (1) | 1 | 0 | 1 | 1 |
+
+A reference cell ran without another adapter to compare against.
+
+Unsupported:
+
+- b/delete: ` + "`conformance_test.go:70: unsupported`" + `
+
+First failures:
+
+- a/delete: ` + "`TestRandom: trie_test.go:10: first`" + `
+`
+	if buf.String() != want {
+		t.Errorf("WriteMarkdown(): got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	err = rpt.WriteJSON(&buf)
+	if err != nil {
+		t.Fatalf("WriteJSON() failed with %s", err)
+	}
+	for _, s := range []string{
+		`"outcome": "fail"`,
+		`"outcome": "unsupported"`,
+		`"outcome": "skipped"`,
+		`"skipped": 1`,
+		`"reference": true`,
+		`"message": "conformance_test.go:70: unsupported"`,
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("WriteJSON(): missing %s in\n%s", s, buf.String())
+		}
+	}
+}
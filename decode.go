@@ -0,0 +1,214 @@
+package trietest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// NodeType is the type of a decoded node.
+type NodeType int
+
+const (
+	NodeBranch NodeType = iota
+	NodeExtension
+	NodeLeaf
+	NodeHash
+)
+
+var nodeTypeNames = []string{"branch", "extension", "leaf", "hash"}
+
+func (nt NodeType) String() string {
+	if nt < 0 || int(nt) >= len(nodeTypeNames) {
+		return fmt.Sprintf("node(%d)", int(nt))
+	}
+	return nodeTypeNames[nt]
+}
+
+// Node is a decoded trie node, along with the nodes below it. Paths are nibbles, one per
+// byte, with the hex prefix removed. A hash node is a reference by hash to a node which was
+// not resolved.
+type Node struct {
+	Type     NodeType
+	Path     []byte    // of an extension or a leaf
+	Value    []byte    // of a branch or a leaf
+	Children [16]*Node // of a branch; nil for an empty slot
+	Next     *Node     // of an extension
+	Hash     []byte    // of a hash node
+	Size     int       // the length of the encoding, other than of a hash node
+}
+
+// DecodeNode decodes b, which is an encoded node, such as the output of Serialize, into a
+// tree of nodes. If ns is not nil, nodes referenced by hash are resolved using it; otherwise
+// they are left as hash nodes. An empty trie, encoded as 0x80, decodes as nil.
+func DecodeNode(b []byte, ns NodeSource) (*Node, error) {
+	if bytes.Equal(b, []byte{0x80}) {
+		return nil, nil
+	}
+
+	n, err := decodeNode(b)
+	if err != nil {
+		return nil, err
+	}
+	return exportNode(n, ns)
+}
+
+// formatSerialized pretty prints b, which is the output of Serialize, or shows it in hex if it
+// can't be decoded.
+func formatSerialized(b []byte) string {
+	n, err := DecodeNode(b, nil)
+	if err != nil {
+		return fmt.Sprintf("%x: %s\n", b, err)
+	}
+	return n.String()
+}
+
+func exportNode(n node, ns NodeSource) (*Node, error) {
+	switch n := n.(type) {
+	case nil:
+		return nil, nil
+	case hashNode:
+		if ns == nil {
+			return &Node{Type: NodeHash, Hash: n}, nil
+		}
+
+		enc, err := ns(n)
+		if err != nil {
+			return nil, fmt.Errorf("trietest: node %x: %w", []byte(n), err)
+		}
+		if h := keccak256(enc); !bytes.Equal(h, n) {
+			return nil, fmt.Errorf("trietest: node %x has hash %x", []byte(n), h)
+		}
+		nn, err := decodeNode(enc)
+		if err != nil {
+			return nil, err
+		}
+		return exportNode(nn, ns)
+	case *branchNode:
+		en := &Node{
+			Type:  NodeBranch,
+			Value: n.value,
+			Size:  len(encodeNode(n)),
+		}
+		for idx, child := range n.children {
+			var err error
+			en.Children[idx], err = exportNode(child, ns)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return en, nil
+	case *extensionNode:
+		next, err := exportNode(n.next, ns)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Type: NodeExtension,
+			Path: n.path,
+			Next: next,
+			Size: len(encodeNode(n)),
+		}, nil
+	case *leafNode:
+		return &Node{
+			Type:  NodeLeaf,
+			Path:  n.path,
+			Value: n.value,
+			Size:  len(encodeNode(n)),
+		}, nil
+	}
+
+	panic(fmt.Sprintf("trietest: unexpected node: %T", n))
+}
+
+// String pretty prints the tree of nodes, one line per node, with the children of a node
+// indented below it; a nil node is an empty trie.
+func (n *Node) String() string {
+	var sb strings.Builder
+	n.write(&sb, "")
+	return sb.String()
+}
+
+func (n *Node) write(sb *strings.Builder, indent string) {
+	if n == nil {
+		fmt.Fprintf(sb, "%sempty\n", indent)
+		return
+	}
+
+	switch n.Type {
+	case NodeBranch:
+		fmt.Fprintf(sb, "%sbranch", indent)
+		if n.Value != nil {
+			fmt.Fprintf(sb, " value %s", formatValue(n.Value))
+		}
+		fmt.Fprintf(sb, " (%d bytes)\n", n.Size)
+		for idx, child := range n.Children {
+			if child != nil {
+				fmt.Fprintf(sb, "%s  %x:\n", indent, idx)
+				child.write(sb, indent+"    ")
+			}
+		}
+	case NodeExtension:
+		fmt.Fprintf(sb, "%sextension path %s (%d bytes)\n", indent, formatPath(n.Path), n.Size)
+		n.Next.write(sb, indent+"  ")
+	case NodeLeaf:
+		fmt.Fprintf(sb, "%sleaf path %s value %s (%d bytes)\n", indent, formatPath(n.Path),
+			formatValue(n.Value), n.Size)
+	case NodeHash:
+		fmt.Fprintf(sb, "%shash %x\n", indent, n.Hash)
+	default:
+		fmt.Fprintf(sb, "%s%s\n", indent, n.Type)
+	}
+}
+
+// formatPath formats nibbles as hex digits, one per nibble.
+func formatPath(path []byte) string {
+	var sb strings.Builder
+	for _, n := range path {
+		sb.WriteByte("0123456789abcdef"[n&0x0F])
+	}
+	return sb.String()
+}
+
+func formatValue(v []byte) string {
+	if len(v) > 16 {
+		return fmt.Sprintf("%s... (%d bytes)", hex.EncodeToString(v[:16]), len(v))
+	}
+	return hex.EncodeToString(v)
+}
+
+type jsonNode struct {
+	Type     string           `json:"type"`
+	Path     string           `json:"path,omitempty"`
+	Value    string           `json:"value,omitempty"`
+	Children map[string]*Node `json:"children,omitempty"`
+	Next     *Node            `json:"next,omitempty"`
+	Hash     string           `json:"hash,omitempty"`
+	Size     int              `json:"size,omitempty"`
+}
+
+// MarshalJSON encodes the tree of nodes as JSON, with paths as one hex digit per nibble,
+// values and hashes in hex, and the children of a branch keyed by their nibble:
+//
+//	{"type":"extension","path":"1a3","next":{"type":"branch","children":{"0":...}}}
+func (n *Node) MarshalJSON() ([]byte, error) {
+	jn := jsonNode{
+		Type:  n.Type.String(),
+		Path:  formatPath(n.Path),
+		Value: hex.EncodeToString(n.Value),
+		Next:  n.Next,
+		Hash:  hex.EncodeToString(n.Hash),
+		Size:  n.Size,
+	}
+	for idx, child := range n.Children {
+		if child != nil {
+			if jn.Children == nil {
+				jn.Children = map[string]*Node{}
+			}
+			jn.Children[fmt.Sprintf("%x", idx)] = child
+		}
+	}
+	return json.Marshal(jn)
+}
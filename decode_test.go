@@ -0,0 +1,145 @@
+package trietest_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+func TestDecodeNode(t *testing.T) {
+	cases := []struct {
+		s    string
+		text string
+		json string
+	}{
+		{
+			s:    "80",
+			text: "empty\n",
+			json: "null",
+		},
+		{
+			s:    "c9842000123483012345",
+			text: "leaf path 001234 value 012345 (10 bytes)\n",
+			json: `{"type":"leaf","path":"001234","value":"012345","size":10}`,
+		},
+		{
+			s: "ebd710d580c2320180c234028080808080808080808080808080808080c23603808080808080" +
+				"8080808081ff",
+			text: `branch value ff (44 bytes)
+  0:
+    extension path 0 (24 bytes)
+      branch (22 bytes)
+        1:
+          leaf path 2 value 01 (3 bytes)
+        3:
+          leaf path 4 value 02 (3 bytes)
+  5:
+    leaf path 6 value 03 (3 bytes)
+`,
+		},
+		{
+			s: "ea881a1b1c1d1e1f1011a0" +
+				"0707070707070707070707070707070707070707070707070707070707070707",
+			text: `extension path a1b1c1d1e1f1011 (43 bytes)
+  hash 0707070707070707070707070707070707070707070707070707070707070707
+`,
+			json: `{"type":"extension","path":"a1b1c1d1e1f1011","next":{"type":"hash",` +
+				`"hash":"0707070707070707070707070707070707070707070707070707070707070707"},` +
+				`"size":43}`,
+		},
+	}
+
+	for _, c := range cases {
+		b, err := hex.DecodeString(c.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := trietest.DecodeNode(b, nil)
+		if err != nil {
+			t.Errorf("DecodeNode(%s) failed with %s", c.s, err)
+			continue
+		}
+		if s := n.String(); s != c.text {
+			t.Errorf("DecodeNode(%s).String(): got\n%swant\n%s", c.s, s, c.text)
+		}
+		if c.json != "" {
+			j, err := json.Marshal(n)
+			if err != nil {
+				t.Errorf("json.Marshal(DecodeNode(%s)) failed with %s", c.s, err)
+			} else if string(j) != c.json {
+				t.Errorf("json.Marshal(DecodeNode(%s)): got %s, want %s", c.s, j, c.json)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "c0", "c28180", "c3823000"} {
+		b, _ := hex.DecodeString(s)
+		_, err := trietest.DecodeNode(b, nil)
+		if err == nil {
+			t.Errorf("DecodeNode(%s) did not fail", s)
+		}
+	}
+}
+
+func TestDecodeNodeSource(t *testing.T) {
+	kv := generatedKeyValues(1, 200, keyGenerators[0], 1, 40)
+	for _, ta := range testAdapters(t, 0) {
+		trie := ta.New()
+		ne, ok := trie.(trietest.NodeExporter)
+		if !ok {
+			continue
+		}
+		for _, kv := range kv {
+			testPutTrie(t, ta.Name, trie, kv.k, kv.v)
+		}
+
+		root, ns, err := ne.ExportNodes()
+		if err != nil {
+			t.Fatalf("%s.ExportNodes() failed with %s", ta.Name, err)
+		}
+		n, err := trietest.DecodeNode(root, ns)
+		if err != nil {
+			t.Fatalf("DecodeNode(%s.ExportNodes()) failed with %s", ta.Name, err)
+		}
+
+		var leaves int
+		var walk func(n *trietest.Node, path []byte)
+		walk = func(n *trietest.Node, path []byte) {
+			switch n.Type {
+			case trietest.NodeBranch:
+				if n.Value != nil {
+					leaves += 1
+				}
+				for idx, child := range n.Children {
+					if child != nil {
+						walk(child, append(append([]byte(nil), path...), byte(idx)))
+					}
+				}
+			case trietest.NodeExtension:
+				walk(n.Next, append(append([]byte(nil), path...), n.Path...))
+			case trietest.NodeLeaf:
+				leaves += 1
+				path = append(append([]byte(nil), path...), n.Path...)
+				k := make([]byte, len(path)/2)
+				for i := range k {
+					k[i] = path[i*2]<<4 | path[i*2+1]
+				}
+				v, err := trie.Get(k)
+				if err != nil || !bytes.Equal(v, n.Value) {
+					t.Errorf("%s: leaf at %x: got %x, %s.Get(): %x, %v", ta.Name, path, n.Value,
+						ta.Name, v, err)
+				}
+			case trietest.NodeHash:
+				t.Errorf("%s: unresolved hash node at %x", ta.Name, path)
+			}
+		}
+		walk(n, nil)
+		if leaves != len(kv) {
+			t.Errorf("DecodeNode(%s.ExportNodes()): got %d values, want %d", ta.Name, leaves,
+				len(kv))
+		}
+	}
+}
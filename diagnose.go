@@ -0,0 +1,210 @@
+package trietest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// NodeExporter is implemented by tries which can export their own nodes: the encoding of the
+// root node, and a source for every node referenced by hash.
+type NodeExporter interface {
+	ExportNodes() ([]byte, NodeSource, error)
+}
+
+// exportNodes returns the encoding of the root node of t and a source for the nodes that it
+// references by hash. A trie which is not a NodeExporter, but can serialize, only has its
+// root node; its source fails for every node.
+func exportNodes(t Trie) ([]byte, NodeSource, error) {
+	var enc []byte
+	var ns NodeSource
+	var err error
+	if ne, ok := t.(NodeExporter); ok {
+		enc, ns, err = ne.ExportNodes()
+	} else if t.Capabilities().Has(CapSerialize) {
+		enc, err = t.Serialize()
+		ns = func(hash []byte) ([]byte, error) {
+			return nil, ErrNotSupported
+		}
+	} else {
+		return nil, nil, ErrNotSupported
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if h := keccak256(enc); !bytes.Equal(h, t.Hash()) {
+		return nil, nil, fmt.Errorf("trietest: root node hash %x does not match trie hash %x",
+			h, t.Hash())
+	}
+	return enc, ns, nil
+}
+
+// exportRoot returns the root node of t, decoded, and a source for the nodes that it
+// references by hash.
+func exportRoot(t Trie) (node, NodeSource, error) {
+	enc, ns, err := exportNodes(t)
+	if err != nil {
+		return nil, nil, err
+	} else if bytes.Equal(enc, []byte{0x80}) {
+		return nil, ns, nil
+	}
+	root, err := decodeNode(enc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, ns, nil
+}
+
+// NodeDiff is the first node, in path order, where two tries differ other than in the
+// references to their children.
+type NodeDiff struct {
+	Path    []byte // the nibbles from the root to the node
+	Ref     string
+	RefNode string // the node of Ref, pretty printed
+	Name    string
+	Node    string // the node of Name, pretty printed
+
+	refType, nodeType string
+}
+
+func (nd *NodeDiff) Error() string {
+	var what string
+	if nd.refType == nd.nodeType {
+		what = fmt.Sprintf("%s encodes this %s differently from %s", nd.Name, nd.nodeType,
+			nd.Ref)
+	} else {
+		what = fmt.Sprintf("%s has %s where %s has %s", nd.Name, withArticle(nd.nodeType),
+			nd.Ref, withArticle(nd.refType))
+	}
+	return fmt.Sprintf("trietest: %s at path 0x%s\n%s:\n%s%s:\n%s", what, formatPath(nd.Path),
+		nd.Ref, indentLines(nd.RefNode, "    "), nd.Name, indentLines(nd.Node, "    "))
+}
+
+// Diagnose walks the nodes of nt and ref, which is the reference, from their roots, and
+// returns the first node, in path order, where they differ other than in the references to
+// their children; nodes referenced by hash are only followed if both tries are NodeExporters.
+// It returns nil if the tries have the same hash, and an error wrapping ErrNotSupported if
+// either cannot export its nodes.
+//
+// The mptrie adapter is not a NodeExporter, because its library does not expose its nodes;
+// only its root node is available, from Serialize. So Diagnose can't find where below the
+// root a difference is for it: it reports that the root node differs, with a path of 0x,
+// unless the difference is in a child that is inlined in the root.
+func Diagnose(ref, nt NamedTrie) (*NodeDiff, error) {
+	if bytes.Equal(ref.Trie.Hash(), nt.Trie.Hash()) {
+		return nil, nil
+	}
+
+	rroot, rns, err := exportRoot(ref.Trie)
+	if err != nil {
+		return nil, fmt.Errorf("trietest: %s: %w", ref.Name, err)
+	}
+	nroot, nns, err := exportRoot(nt.Trie)
+	if err != nil {
+		return nil, fmt.Errorf("trietest: %s: %w", nt.Name, err)
+	}
+
+	rn, nn, path := diffNodes(nil, rroot, nroot, rns, nns)
+	return &NodeDiff{
+		Path:     path,
+		Ref:      ref.Name,
+		RefNode:  formatNode(rn),
+		Name:     nt.Name,
+		Node:     formatNode(nn),
+		refType:  nodeType(rn),
+		nodeType: nodeType(nn),
+	}, nil
+}
+
+// diffNodes returns the first pair of nodes below path, starting at a and b, which differ
+// other than in the references to their children, along with their path. If the only
+// difference between two children is how they are referenced, or a child can't be resolved,
+// it is a difference in the parent.
+func diffNodes(path []byte, a, b node, ans, bns NodeSource) (node, node, []byte) {
+	switch a := a.(type) {
+	case *branchNode:
+		if b, ok := b.(*branchNode); ok && bytes.Equal(a.value, b.value) {
+			for idx := range a.children {
+				if bytes.Equal(nodeRef(a.children[idx]), nodeRef(b.children[idx])) {
+					continue
+				}
+
+				ac, aok := resolveNode(a.children[idx], ans)
+				bc, bok := resolveNode(b.children[idx], bns)
+				if !aok || !bok || bytes.Equal(encodeNode(ac), encodeNode(bc)) {
+					break
+				}
+				return diffNodes(joinPath(path, []byte{byte(idx)}), ac, bc, ans, bns)
+			}
+		}
+	case *extensionNode:
+		if b, ok := b.(*extensionNode); ok && bytes.Equal(a.path, b.path) {
+			an, aok := resolveNode(a.next, ans)
+			bn, bok := resolveNode(b.next, bns)
+			if aok && bok && !bytes.Equal(encodeNode(an), encodeNode(bn)) {
+				return diffNodes(joinPath(path, a.path), an, bn, ans, bns)
+			}
+		}
+	}
+	return a, b, path
+}
+
+// resolveNode returns n, getting it from ns if it is a hashNode; it returns false if it
+// can't.
+func resolveNode(n node, ns NodeSource) (node, bool) {
+	hn, ok := n.(hashNode)
+	if !ok {
+		return n, n != nil
+	}
+
+	enc, err := ns(hn)
+	if err != nil || !bytes.Equal(keccak256(enc), hn) {
+		return nil, false
+	}
+	nn, err := decodeNode(enc)
+	if err != nil {
+		return nil, false
+	}
+	return nn, true
+}
+
+func nodeType(n node) string {
+	switch n.(type) {
+	case nil:
+		return "empty"
+	case *branchNode:
+		return "branch"
+	case *extensionNode:
+		return "extension"
+	case *leafNode:
+		return "leaf"
+	case hashNode:
+		return "hash"
+	}
+	panic(fmt.Sprintf("trietest: unexpected node: %T", n))
+}
+
+func withArticle(s string) string {
+	if strings.ContainsRune("aeiou", rune(s[0])) {
+		return "an " + s
+	}
+	return "a " + s
+}
+
+// formatNode pretty prints n as Node.String does, with children referenced by hash shown as
+// the hash.
+func formatNode(n node) string {
+	en, _ := exportNode(n, nil)
+	return en.String()
+}
+
+func indentLines(s, indent string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = indent + l
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,98 @@
+package trietest_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+func testDiagnoseTrie(t *testing.T, newTrie trietest.Factory, kv []keyValue) trietest.Trie {
+	t.Helper()
+
+	trie := newTrie()
+	for _, kv := range kv {
+		testPutTrie(t, "trie", trie, kv.k, kv.v)
+	}
+	return trie
+}
+
+func TestDiagnose(t *testing.T) {
+	a := bytes.Repeat([]byte{0xAA}, 40)
+	b := bytes.Repeat([]byte{0xBB}, 40)
+	kv1 := []keyValue{
+		{k: []byte{0x12, 0x34}, v: a},
+		{k: []byte{0x12, 0x56}, v: a},
+	}
+	kv2 := []keyValue{
+		{k: []byte{0x12, 0x34}, v: a},
+		{k: []byte{0x12, 0x56}, v: b},
+	}
+	kv3 := []keyValue{
+		{k: []byte{0x12, 0x34}, v: a},
+		{k: []byte{0x12}, v: a},
+	}
+
+	// A difference below the root node is only found if both tries are NodeExporters;
+	// otherwise, Diagnose only finds that the root nodes differ.
+	cases := []struct {
+		kv   []keyValue
+		path []byte
+		msg  string
+		root string
+	}{
+		{
+			kv:   kv2,
+			path: []byte{0x01, 0x02, 0x05},
+			msg:  "%s encodes this leaf differently from %s at path 0x125",
+			root: "%s encodes this extension differently from %s at path 0x\n",
+		},
+		{
+			kv:   kv3,
+			path: []byte{0x01, 0x02},
+			msg:  "%s encodes this branch differently from %s at path 0x12",
+			root: "%s encodes this extension differently from %s at path 0x\n",
+		},
+		{
+			kv:   kv1[:1],
+			path: nil,
+			msg:  "%s has a leaf where %s has an extension at path 0x\n",
+			root: "%s has a leaf where %s has an extension at path 0x\n",
+		},
+	}
+
+	tas := testAdapters(t, 0)
+	ref := tas[0]
+	for _, ta := range tas {
+		_, refExporter := ref.New().(trietest.NodeExporter)
+		_, exporter := ta.New().(trietest.NodeExporter)
+		for _, c := range cases {
+			nd, err := trietest.Diagnose(
+				trietest.NamedTrie{Name: ref.Name, Trie: testDiagnoseTrie(t, ref.New, kv1)},
+				trietest.NamedTrie{Name: ta.Name, Trie: testDiagnoseTrie(t, ta.New, c.kv)})
+			path, msg := c.path, fmt.Sprintf(c.msg, ta.Name, ref.Name)
+			if !refExporter || !exporter {
+				path, msg = nil, fmt.Sprintf(c.root, ta.Name, ref.Name)
+			}
+			if err != nil {
+				t.Errorf("Diagnose(%s, %s) failed with %s", ref.Name, ta.Name, err)
+			} else if nd == nil {
+				t.Errorf("Diagnose(%s, %s) did not find a difference", ref.Name, ta.Name)
+			} else if !bytes.Equal(nd.Path, path) || !strings.Contains(nd.Error(), msg) {
+				t.Errorf("Diagnose(%s, %s): got %v %s, want %v %s", ref.Name, ta.Name, nd.Path,
+					nd, path, msg)
+			}
+		}
+	}
+
+	for _, ta := range tas {
+		nd, err := trietest.Diagnose(
+			trietest.NamedTrie{Name: ta.Name, Trie: testDiagnoseTrie(t, ta.New, kv1)},
+			trietest.NamedTrie{Name: ta.Name, Trie: testDiagnoseTrie(t, ta.New, kv1)})
+		if err != nil || nd != nil {
+			t.Errorf("Diagnose(%s, %s) with the same keys: got %v, %v", ta.Name, ta.Name, nd, err)
+		}
+	}
+}
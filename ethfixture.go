@@ -0,0 +1,116 @@
+package trietest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EthereumFixture is a test from the trie tests of the Ethereum tests repository. The last
+// step of Trace is a hash op with the expected root hash.
+type EthereumFixture struct {
+	Name  string
+	Trace Trace
+}
+
+type ethereumFixture struct {
+	In   json.RawMessage `json:"in"`
+	Root string          `json:"root"`
+}
+
+// ReadEthereumFixtures reads fixtures in the format of trietest.json, trieanyorder.json, and
+// hex_encoded_securetrie_test.json from r, sorted by name. The "in" of a fixture is either a
+// list of key/value pairs, which are applied in order, or an object, whose keys are applied in
+// sorted order. Strings which start with 0x are hex; other strings are used as bytes. A null
+// or empty value deletes the key. If secure, the keys are hashed first, as in a secure trie.
+func ReadEthereumFixtures(r io.Reader, secure bool) ([]EthereumFixture, error) {
+	var fixtures map[string]ethereumFixture
+	err := json.NewDecoder(r).Decode(&fixtures)
+	if err != nil {
+		return nil, err
+	}
+
+	var efs []EthereumFixture
+	for name, f := range fixtures {
+		tr, err := f.trace(secure)
+		if err != nil {
+			return nil, fmt.Errorf("trietest: fixture %s: %s", name, err)
+		}
+		efs = append(efs, EthereumFixture{Name: name, Trace: tr})
+	}
+	sort.Slice(efs,
+		func(i, j int) bool {
+			return efs[i].Name < efs[j].Name
+		})
+	return efs, nil
+}
+
+func (f ethereumFixture) trace(secure bool) (Trace, error) {
+	var pairs [][]*string
+	var anyOrder bool
+	err := json.Unmarshal(f.In, &pairs)
+	if err != nil {
+		anyOrder = true
+		var in map[string]*string
+		if json.Unmarshal(f.In, &in) != nil {
+			return nil, fmt.Errorf("in must be a list of pairs or an object: %s", err)
+		}
+		for k, v := range in {
+			k := k
+			pairs = append(pairs, []*string{&k, v})
+		}
+	}
+
+	var tr Trace
+	for _, p := range pairs {
+		if len(p) != 2 || p[0] == nil {
+			return nil, fmt.Errorf("in must be pairs of a key and a value")
+		}
+		k, err := fixtureBytes(*p[0])
+		if err != nil {
+			return nil, err
+		}
+		if secure {
+			k = keccak256(k)
+		}
+
+		op := Op{Kind: OpDelete, Key: k}
+		if p[1] != nil {
+			v, err := fixtureBytes(*p[1])
+			if err != nil {
+				return nil, err
+			}
+			if len(v) > 0 {
+				op.Kind = OpPut
+				op.Value = v
+			}
+		}
+		tr = append(tr, TraceStep{Op: op})
+	}
+
+	if anyOrder {
+		sort.SliceStable(tr,
+			func(i, j int) bool {
+				return bytes.Compare(tr[i].Key, tr[j].Key) < 0
+			})
+	}
+
+	root, err := fixtureBytes(f.Root)
+	if err != nil {
+		return nil, err
+	} else if len(root) != 32 {
+		return nil, fmt.Errorf("root must be 32 bytes: %s", f.Root)
+	}
+	return append(tr, TraceStep{Op: Op{Kind: OpHash}, Hash: root}), nil
+}
+
+func fixtureBytes(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "0x") {
+		return hex.DecodeString(s[2:])
+	}
+	return []byte(s), nil
+}
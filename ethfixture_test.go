@@ -0,0 +1,63 @@
+package trietest_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+// The files in testdata/ethereum are TrieTests of github.com/ethereum/tests, unchanged, as
+// copied into tests/files/TrieTests of go-ethereum v1.6.0. That revision predates the
+// insert-middle-leaf and branch-value-update tests of trietest.json; newer files can be
+// dropped in as is.
+var ethereumFixtures = []struct {
+	file   string
+	secure bool
+}{
+	{file: "trietest.json"},
+	{file: "trietest_secureTrie.json", secure: true},
+	{file: "trieanyorder.json"},
+	{file: "trieanyorder_secureTrie.json", secure: true},
+	{file: "hex_encoded_securetrie_test.json", secure: true},
+}
+
+func TestEthereumFixtures(t *testing.T) {
+	tas := testAdapters(t, 0)
+
+	for _, ef := range ethereumFixtures {
+		f, err := os.Open(filepath.Join("testdata", "ethereum", ef.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		efs, err := trietest.ReadEthereumFixtures(f, ef.secure)
+		f.Close()
+		if err != nil {
+			t.Fatalf("ReadEthereumFixtures(%s) failed with %s", ef.file, err)
+		}
+		if len(efs) == 0 {
+			t.Errorf("ReadEthereumFixtures(%s): no fixtures", ef.file)
+		}
+
+		for _, fix := range efs {
+			var caps trietest.Capabilities
+			for _, ts := range fix.Trace {
+				if ts.Kind == trietest.OpDelete {
+					caps |= trietest.CapDelete
+				}
+			}
+
+			for _, ta := range tas {
+				tr := ta.New()
+				if !tr.Capabilities().Has(caps) {
+					continue
+				}
+				err := trietest.Replay(fix.Trace, trietest.NamedTrie{Name: ta.Name, Trie: tr})
+				if err != nil {
+					t.Errorf("%s: %s: %s", ef.file, fix.Name, err)
+				}
+			}
+		}
+	}
+}
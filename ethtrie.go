@@ -1,6 +1,7 @@
 package trietest
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -8,21 +9,33 @@ import (
 	ethtrie "github.com/ethereum/go-ethereum/trie"
 )
 
+func init() {
+	Register("eth", NewEthTrie)
+	Register("stacktrie", NewStackTrie)
+}
+
 type ethTrie struct {
 	trie *ethtrie.Trie
+	db   *ethtrie.Database
 }
 
 func NewEthTrie() Trie {
-	trie, err := ethtrie.New(common.Hash{}, ethtrie.NewDatabase(memorydb.New()))
+	db := ethtrie.NewDatabase(memorydb.New())
+	trie, err := ethtrie.New(common.Hash{}, db)
 	if err != nil {
 		panic(fmt.Sprintf("ethtrie: %s", err))
 	}
 
 	return ethTrie{
 		trie: trie,
+		db:   db,
 	}
 }
 
+func (_ ethTrie) Capabilities() Capabilities {
+	return CapDelete | CapIncrementalHash | CapIterate | CapProve | CapRandomAccess
+}
+
 func (et ethTrie) Delete(key []byte) error {
 	val, err := et.trie.TryGet(key)
 	if len(val) == 0 {
@@ -47,10 +60,191 @@ func (et ethTrie) Hash() []byte {
 	return h[:]
 }
 
+func (et ethTrie) Iterate(start, prefix []byte) Iterator {
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+
+	return &ethIterator{
+		trie:   et.trie,
+		it:     ethtrie.NewIterator(et.trie.NodeIterator(start)),
+		start:  start,
+		prefix: prefix,
+	}
+}
+
+func (et ethTrie) Prove(key []byte) ([][]byte, error) {
+	var pl proofList
+	err := et.trie.Prove(key, 0, &pl)
+	if err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// ExportNodes commits a copy of the trie to its database, which leaves the trie itself
+// unchanged, and returns the nodes from the database.
+func (et ethTrie) ExportNodes() ([]byte, NodeSource, error) {
+	cpy := *et.trie
+	root, err := cpy.Commit(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var proofs NodeSource
+	ns := func(hash []byte) ([]byte, error) {
+		enc, err := et.db.Node(common.BytesToHash(hash))
+		if err == nil && bytes.Equal(keccak256(enc), hash) {
+			return enc, nil
+		}
+
+		// The database has the hash of a value of a branch in place of the value, if it is 32
+		// bytes or longer, so those nodes come from the proofs of all of the keys instead.
+		if proofs == nil {
+			proofs, err = et.proofNodes()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return proofs(hash)
+	}
+	if bytes.Equal(root[:], emptyRoot) {
+		return []byte{0x80}, ns, nil
+	}
+	enc, err := ns(root[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, ns, nil
+}
+
+func (et ethTrie) proofNodes() (NodeSource, error) {
+	var proofs [][]byte
+	it := et.Iterate(nil, nil)
+	for it.Next() {
+		proof, err := et.Prove(it.Key())
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof...)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return ProofNodes(proofs), nil
+}
+
 func (et ethTrie) Put(key, val []byte) error {
 	return et.trie.TryUpdate(key, val)
 }
 
-func (_ ethTrie) Serialize() ([]byte, bool) {
-	return nil, false
+func (_ ethTrie) Serialize() ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+// proofList collects the nodes written by Trie.Prove in order.
+type proofList [][]byte
+
+func (pl *proofList) Put(key []byte, val []byte) error {
+	*pl = append(*pl, val)
+	return nil
+}
+
+func (_ *proofList) Delete(key []byte) error {
+	return ErrNotSupported
+}
+
+type ethIterator struct {
+	trie    *ethtrie.Trie
+	it      *ethtrie.Iterator
+	start   []byte
+	prefix  []byte
+	pending []keyValue
+	last    []byte
+	key     []byte
+	val     []byte
+	err     error
+	done    bool
+}
+
+// after returns true if key comes after the last key returned by the iterator, or is at
+// least start if no keys have been returned yet.
+func (ei *ethIterator) after(key []byte) bool {
+	if ei.last != nil {
+		return bytes.Compare(key, ei.last) > 0
+	}
+	return bytes.Compare(key, ei.start) >= 0
+}
+
+type keyValue struct {
+	key, val []byte
+}
+
+func (ei *ethIterator) Next() bool {
+	if len(ei.pending) == 0 && !ei.done {
+		ei.fill()
+	}
+
+	if len(ei.pending) == 0 {
+		ei.key = nil
+		ei.val = nil
+		return false
+	}
+
+	ei.key = ei.pending[0].key
+	ei.val = ei.pending[0].val
+	ei.pending = ei.pending[1:]
+	return true
+}
+
+// fill adds the next key to pending. The value of a branch node is returned by the node
+// iterator after its children, so any keys which are prefixes of the next key have to be
+// looked up and added first; they are skipped when the node iterator gets to them.
+func (ei *ethIterator) fill() {
+	for ei.it.Next() {
+		if !ei.after(ei.it.Key) {
+			continue
+		}
+		if !bytes.HasPrefix(ei.it.Key, ei.prefix) {
+			break
+		}
+
+		key := append([]byte{}, ei.it.Key...)
+		for kl := len(ei.prefix); kl < len(key); kl += 1 {
+			if !ei.after(key[:kl]) {
+				continue
+			}
+
+			val, err := ei.trie.TryGet(key[:kl])
+			if err != nil {
+				ei.err = err
+				ei.done = true
+				return
+			} else if len(val) > 0 {
+				ei.pending = append(ei.pending, keyValue{key: key[:kl], val: val})
+			}
+		}
+
+		ei.pending = append(ei.pending,
+			keyValue{key: key, val: append([]byte(nil), ei.it.Value...)})
+		ei.last = key
+		return
+	}
+
+	ei.done = true
+}
+
+func (ei *ethIterator) Key() []byte {
+	return ei.key
+}
+
+func (ei *ethIterator) Value() []byte {
+	return ei.val
+}
+
+func (ei *ethIterator) Err() error {
+	if ei.err != nil {
+		return ei.err
+	}
+	return ei.it.Err
 }
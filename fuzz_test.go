@@ -0,0 +1,120 @@
+package trietest_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+const fuzzMaxOps = 256
+
+// fuzzOps decodes the input of a fuzz target into ops. Each op starts with a byte: the low
+// three bits are the kind (0 to 3 put, 4 and 5 delete, 6 get, and 7 hash) and, if bit 3 is
+// set, the high four bits select a key that has already been used. Otherwise, the next byte
+// is the length of the key (mod 9) followed by the key. A put is followed by a byte with the
+// length of the value (mod 48, plus 1), which is filled with the first byte of the op, so
+// values cross the 32 byte boundary between inline and hashed nodes. Bytes missing at the
+// end of the input are zero.
+func fuzzOps(b []byte, withDelete bool) []trietest.Op {
+	next := func() byte {
+		if len(b) == 0 {
+			return 0
+		}
+		c := b[0]
+		b = b[1:]
+		return c
+	}
+
+	var ops []trietest.Op
+	var keys [][]byte
+	for len(b) > 0 && len(ops) < fuzzMaxOps {
+		c := next()
+
+		var op trietest.Op
+		switch c & 0x07 {
+		case 0, 1, 2, 3:
+			op.Kind = trietest.OpPut
+		case 4, 5:
+			op.Kind = trietest.OpDelete
+		case 6:
+			op.Kind = trietest.OpGet
+		case 7:
+			ops = append(ops, trietest.Op{Kind: trietest.OpHash})
+			continue
+		}
+
+		if c&0x08 != 0 && len(keys) > 0 {
+			op.Key = keys[int(c>>4)%len(keys)]
+		} else {
+			op.Key = make([]byte, next()%9)
+			for i := range op.Key {
+				op.Key[i] = next()
+			}
+			keys = append(keys, op.Key)
+		}
+
+		if op.Kind == trietest.OpPut {
+			op.Value = bytes.Repeat([]byte{c}, int(next()%48)+1)
+		} else if op.Kind == trietest.OpDelete && !withDelete {
+			op.Kind = trietest.OpGet
+		}
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+func fuzzLockstep(t *testing.T, ops []trietest.Op, caps trietest.Capabilities) {
+	t.Helper()
+
+	run := newTestRun(t, 0, testAdapters(t, caps))
+	defer run.done()
+	if !run.lockstep(ops, []trietest.Op{{Kind: trietest.OpSerialize}}) {
+		return
+	}
+
+	kv := map[string][]byte{}
+	for _, op := range ops {
+		switch op.Kind {
+		case trietest.OpDelete:
+			delete(kv, string(op.Key))
+		case trietest.OpPut:
+			kv[string(op.Key)] = op.Value
+		}
+	}
+	skv := testModel(kv).keyValues()
+	for _, nt := range run.tries {
+		testIterateTrie(t, nt.Name, nt.Trie, nil, nil, skv)
+	}
+}
+
+// fuzzSeeds adds the seeds of both fuzz targets; testdata/fuzz only has inputs which the
+// fuzzer found to fail.
+func fuzzSeeds(f *testing.F) {
+	f.Add([]byte{0x00, 0x01, 0x01, 0x1F, 0x00, 0x02, 0x01, 0x23, 0x1F, 0x07})
+	f.Add([]byte{0x00, 0x00, 0x20, 0x01, 0x01, 0x10, 0x05, 0x0C, 0x17})
+	f.Add([]byte{0x01, 0x02, 0x12, 0x34, 0x1E, 0x02, 0x02, 0x12, 0x35, 0x1F, 0x0C, 0x07})
+	f.Add([]byte{0x00, 0x03, 0x11, 0x22, 0x33, 0x01, 0x00, 0x03, 0x11, 0x22, 0x34, 0x01, 0x00,
+		0x03, 0x11, 0x23, 0x00, 0x01, 0x07, 0x1C, 0x07, 0x2C, 0x0E, 0x07})
+	f.Add([]byte{0x00, 0x02, 0xAB, 0xCD, 0x1E, 0x00, 0x02, 0xAB, 0xCE, 0x1F, 0x07, 0x08, 0x20,
+		0x07, 0x14, 0x0C})
+}
+
+// FuzzPut runs the ops from the fuzzer, without deletes, against all of the adapters.
+func FuzzPut(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(
+		func(t *testing.T, b []byte) {
+			fuzzLockstep(t, fuzzOps(b, false), 0)
+		})
+}
+
+// FuzzOps runs the ops from the fuzzer against the adapters which support delete.
+func FuzzOps(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(
+		func(t *testing.T, b []byte) {
+			fuzzLockstep(t, fuzzOps(b, true), trietest.CapDelete)
+		})
+}
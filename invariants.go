@@ -0,0 +1,175 @@
+package trietest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Violation is a node which breaks one of the invariants of a canonical trie.
+type Violation struct {
+	Path   []byte // the nibbles from the root to the node
+	Reason string
+	Node   string // the node, pretty printed
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("at path 0x%s: %s\n%s", formatPath(v.Path), v.Reason,
+		indentLines(v.Node, "    "))
+}
+
+// CheckNodes checks that the trie whose root node is encoded as root is canonical, and
+// returns the violations in path order:
+//   - an extension has a path and is followed by a branch, not by another extension or a leaf
+//   - a branch has at least two children, or a child and a value
+//   - the hex prefix flag of a node matches its type
+//   - a node is inlined in its parent exactly when its encoding is less than 32 bytes
+//
+// Nodes referenced by hash are resolved using ns; those which it does not have are not
+// checked.
+func CheckNodes(root []byte, ns NodeSource) []Violation {
+	if bytes.Equal(root, []byte{0x80}) {
+		return nil
+	}
+
+	var vs []Violation
+	checkNode(&vs, nil, root, ns, false)
+	return vs
+}
+
+// CheckTrie checks the nodes of t, using CheckNodes. If t is not a NodeExporter, only its
+// root node, from Serialize, is checked; if it can't serialize either, CheckTrie returns
+// ErrNotSupported.
+func CheckTrie(t Trie) ([]Violation, error) {
+	enc, ns, err := exportNodes(t)
+	if err != nil {
+		return nil, err
+	}
+	return CheckNodes(enc, ns), nil
+}
+
+// checkNode checks the node encoded as enc at path, and the nodes below it, and returns its
+// type. Inline is true if the node is inlined in its parent. Path is nil only for the root
+// node, which is neither inlined nor referenced by hash.
+func checkNode(vs *[]Violation, path, enc []byte, ns NodeSource, inline bool) string {
+	violation := func(format string, args ...interface{}) {
+		*vs = append(*vs,
+			Violation{
+				Path:   path,
+				Reason: fmt.Sprintf(format, args...),
+				Node:   formatSerialized(enc),
+			})
+	}
+
+	elems, _, err := rlp.SplitList(enc)
+	if err != nil {
+		violation("invalid encoding: %s", err)
+		return ""
+	}
+	cnt, err := rlp.CountValues(elems)
+	if err != nil {
+		violation("invalid encoding: %s", err)
+		return ""
+	}
+
+	if inline && len(enc) >= 32 {
+		violation("node of %d bytes is inlined", len(enc))
+	} else if !inline && path != nil && len(enc) < 32 {
+		violation("node of %d bytes is referenced by hash", len(enc))
+	}
+
+	switch cnt {
+	case 2:
+		at := len(*vs)
+		hp, rest, _ := rlp.SplitString(elems)
+		np, leaf, err := decodeHexPrefix(hp)
+		if err != nil {
+			violation("%s", err)
+			return ""
+		}
+		kind, val, _, err := rlp.Split(rest)
+		if err != nil {
+			violation("invalid encoding: %s", err)
+			return ""
+		}
+
+		if leaf {
+			if kind == rlp.List {
+				violation("hex prefix flag is a leaf, but the node has a child")
+				return ""
+			}
+			return "leaf"
+		}
+
+		var next string
+		if kind == rlp.List {
+			next = checkNode(vs, joinPath(path, np), rest, ns, true)
+		} else if len(val) == 32 {
+			next = checkHashNode(vs, joinPath(path, np), val, ns)
+		} else {
+			violation("hex prefix flag is an extension, but the node has a value of %d bytes",
+				len(val))
+			return ""
+		}
+
+		// The violations of the extension go before those of the nodes below it.
+		n := len(*vs)
+		if len(np) == 0 {
+			violation("extension with an empty path")
+		}
+		if next == "extension" || next == "leaf" {
+			violation("extension followed by %s", withArticle(next))
+		}
+		ext := append([]Violation(nil), (*vs)[n:]...)
+		copy((*vs)[at+len(ext):], (*vs)[at:n])
+		copy((*vs)[at:], ext)
+		return "extension"
+
+	case 17:
+		var children int
+		for idx := 0; idx < 16; idx += 1 {
+			kind, val, rest, err := rlp.Split(elems)
+			if err != nil {
+				violation("invalid encoding: %s", err)
+				return ""
+			}
+			cpath := joinPath(path, []byte{byte(idx)})
+			if kind == rlp.List {
+				checkNode(vs, cpath, elems[:len(elems)-len(rest)], ns, true)
+				children += 1
+			} else if len(val) == 32 {
+				checkHashNode(vs, cpath, val, ns)
+				children += 1
+			} else if len(val) > 0 {
+				violation("child %x of branch is a value of %d bytes", idx, len(val))
+			}
+			elems = rest
+		}
+		val, _, err := rlp.SplitString(elems)
+		if err != nil {
+			violation("value of branch: %s", err)
+		}
+
+		if children == 0 && len(val) == 0 {
+			violation("branch with no children and no value")
+		} else if children == 0 {
+			violation("branch with a value and no children")
+		} else if children == 1 && len(val) == 0 {
+			violation("branch with one child and no value")
+		}
+		return "branch"
+	}
+
+	violation("list of %d elements", cnt)
+	return ""
+}
+
+// checkHashNode checks the node with hash at path, if ns has it.
+func checkHashNode(vs *[]Violation, path, hash []byte, ns NodeSource) string {
+	enc, err := ns(hash)
+	if err != nil || !bytes.Equal(keccak256(enc), hash) {
+		return ""
+	}
+	return checkNode(vs, path, enc, ns, false)
+}
@@ -0,0 +1,137 @@
+package trietest_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/leftmike/trietest"
+)
+
+// testEncode encodes items as an RLP list; a []byte is a string and rlp.RawValue is an
+// encoded node.
+func testEncode(items ...interface{}) []byte {
+	b, err := rlp.EncodeToBytes(items)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// testBranch encodes a branch with children, which are encoded nodes or hashes, and value.
+func testBranch(children map[int][]byte, value []byte) []byte {
+	items := make([]interface{}, 0, 17)
+	for idx := 0; idx < 16; idx += 1 {
+		c, ok := children[idx]
+		if !ok {
+			items = append(items, []byte{})
+		} else if len(c) == 32 {
+			items = append(items, c)
+		} else {
+			items = append(items, rlp.RawValue(c))
+		}
+	}
+	return testEncode(append(items, value)...)
+}
+
+func TestCheckNodes(t *testing.T) {
+	leaf1 := testEncode([]byte{0x31}, []byte{0x01})
+	leaf2 := testEncode([]byte{0x32}, []byte{0x02})
+	branch := testBranch(map[int][]byte{1: leaf1, 2: leaf2}, nil)
+	long := testEncode([]byte{0x20}, bytes.Repeat([]byte{0xAA}, 40))
+
+	cases := []struct {
+		root   []byte
+		nodes  [][]byte
+		path   []byte
+		reason string
+	}{
+		{
+			root: testBranch(map[int][]byte{1: leaf1, 2: leaf2}, []byte{0xFF}),
+		},
+		{
+			root:   testEncode([]byte{0x11}, rlp.RawValue(testEncode([]byte{0x12}, rlp.RawValue(branch)))),
+			path:   nil,
+			reason: "extension followed by an extension",
+		},
+		{
+			root:   testEncode([]byte{0x11}, rlp.RawValue(leaf1)),
+			path:   nil,
+			reason: "extension followed by a leaf",
+		},
+		{
+			root:   testEncode([]byte{0x00}, rlp.RawValue(branch)),
+			path:   nil,
+			reason: "extension with an empty path",
+		},
+		{
+			root:   testBranch(map[int][]byte{3: branch}, nil),
+			path:   nil,
+			reason: "branch with one child and no value",
+		},
+		{
+			root:   testBranch(map[int][]byte{3: testBranch(nil, []byte{0x01}), 4: leaf1}, nil),
+			path:   []byte{0x03},
+			reason: "branch with a value and no children",
+		},
+		{
+			root:   testEncode([]byte{0x31}, rlp.RawValue(branch)),
+			path:   nil,
+			reason: "hex prefix flag is a leaf, but the node has a child",
+		},
+		{
+			root:   testEncode([]byte{0x11}, []byte{0x01, 0x02, 0x03}),
+			path:   nil,
+			reason: "hex prefix flag is an extension, but the node has a value of 3 bytes",
+		},
+		{
+			root:   testEncode([]byte{0x41}, []byte{0x01}),
+			path:   nil,
+			reason: "hex prefix flag 4",
+		},
+		{
+			root:   testBranch(map[int][]byte{5: long, 6: leaf2}, nil),
+			path:   []byte{0x05},
+			reason: "node of 43 bytes is inlined",
+		},
+		{
+			root:   testBranch(map[int][]byte{5: crypto.Keccak256(leaf1), 6: leaf2}, nil),
+			nodes:  [][]byte{leaf1},
+			path:   []byte{0x05},
+			reason: "node of 3 bytes is referenced by hash",
+		},
+		{
+			// Nodes which the source does not have are not checked.
+			root: testBranch(map[int][]byte{5: crypto.Keccak256(leaf1), 6: leaf2}, nil),
+		},
+	}
+
+	for _, c := range cases {
+		vs := trietest.CheckNodes(c.root, trietest.ProofNodes(c.nodes))
+		if c.reason == "" {
+			if len(vs) > 0 {
+				t.Errorf("CheckNodes(%x): got %v", c.root, vs)
+			}
+		} else if len(vs) != 1 {
+			t.Errorf("CheckNodes(%x): got %v, want %s", c.root, vs, c.reason)
+		} else if !bytes.Equal(vs[0].Path, c.path) || !strings.Contains(vs[0].Reason, c.reason) {
+			t.Errorf("CheckNodes(%x): got %x %s, want %x %s", c.root, vs[0].Path, vs[0].Reason,
+				c.path, c.reason)
+		}
+	}
+}
+
+func TestCheckTrie(t *testing.T) {
+	for _, kg := range keyGenerators {
+		kv := generatedKeyValues(1, 500, kg, 1, 64)
+		for _, ta := range testAdapters(t, trietest.CapSerialize) {
+			trie := ta.New()
+			for _, kv := range kv {
+				testPutTrie(t, ta.Name, trie, kv.k, kv.v)
+			}
+			testCheckTrie(t, ta.Name, trie)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package trietest
+
+import (
+	"bytes"
+	"sort"
+)
+
+// keySet tracks the keys in a trie for adapters whose underlying library does not support
+// iteration; the values are always read back from the trie itself. Iterating over such a trie
+// is mostly a test of its Get, since the keys come from the keySet rather than the library, so
+// these adapters do not have CapIterate.
+type keySet map[string]struct{}
+
+func (ks keySet) add(key []byte) {
+	ks[string(key)] = struct{}{}
+}
+
+func (ks keySet) remove(key []byte) {
+	delete(ks, string(key))
+}
+
+func (ks keySet) iterate(start, prefix []byte, get func(key []byte) ([]byte, error)) Iterator {
+	var keys []string
+	for k := range ks {
+		if bytes.Compare([]byte(k), start) >= 0 && bytes.HasPrefix([]byte(k), prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return &keySetIterator{
+		keys: keys,
+		get:  get,
+	}
+}
+
+type keySetIterator struct {
+	keys []string
+	get  func(key []byte) ([]byte, error)
+	key  []byte
+	val  []byte
+	err  error
+}
+
+func (ksi *keySetIterator) Next() bool {
+	if ksi.err != nil || len(ksi.keys) == 0 {
+		ksi.key = nil
+		ksi.val = nil
+		return false
+	}
+
+	ksi.key = []byte(ksi.keys[0])
+	ksi.keys = ksi.keys[1:]
+	ksi.val, ksi.err = ksi.get(ksi.key)
+	return ksi.err == nil
+}
+
+func (ksi *keySetIterator) Key() []byte {
+	return ksi.key
+}
+
+func (ksi *keySetIterator) Value() []byte {
+	return ksi.val
+}
+
+func (ksi *keySetIterator) Err() error {
+	return ksi.err
+}
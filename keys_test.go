@@ -0,0 +1,208 @@
+package trietest_test
+
+import (
+	"encoding/binary"
+	"flag"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var keysFlag = flag.String("trietest.keys", "",
+	"comma separated list of the key generators for the random tests; all of them by default")
+
+// keyGenerator is a distribution of keys: newKeys returns a function which returns another
+// key each time that it is called; keys may repeat.
+type keyGenerator struct {
+	name    string
+	newKeys func(r *rand.Rand) func() []byte
+}
+
+var keyGenerators = []keyGenerator{
+	{
+		name: "uniform",
+		newKeys: func(r *rand.Rand) func() []byte {
+			return func() []byte {
+				return randomBytes(r, 1, 64)
+			}
+		},
+	},
+	{
+		// A few long prefixes shared by all of the keys.
+		name: "prefix",
+		newKeys: func(r *rand.Rand) func() []byte {
+			var prefixes [][]byte
+			for len(prefixes) < 4 {
+				prefixes = append(prefixes, randomBytes(r, 20, 30))
+			}
+			return func() []byte {
+				p := prefixes[r.Intn(len(prefixes))]
+				return append(append([]byte(nil), p...), randomBytes(r, 1, 4)...)
+			}
+		},
+	},
+	{
+		// Big-endian integers counting up from zero.
+		name: "sequential",
+		newKeys: func(r *rand.Rand) func() []byte {
+			var i uint64
+			return func() []byte {
+				k := make([]byte, 8)
+				binary.BigEndian.PutUint64(k, i)
+				i += 1
+				return k
+			}
+		},
+	},
+	{
+		// Hashed 32 byte keys, as in the Ethereum state trie.
+		name: "keccak",
+		newKeys: func(r *rand.Rand) func() []byte {
+			return func() []byte {
+				return crypto.Keccak256(randomBytes(r, 1, 32))
+			}
+		},
+	},
+	{
+		// Slash separated ASCII paths.
+		name: "path",
+		newKeys: func(r *rand.Rand) func() []byte {
+			segments := []string{"a", "b", "bin", "etc", "go", "lib", "src", "usr"}
+			return func() []byte {
+				var sb strings.Builder
+				for d := r.Intn(6) + 1; d > 0; d -= 1 {
+					sb.WriteByte('/')
+					sb.WriteString(segments[r.Intn(len(segments))])
+				}
+				return []byte(sb.String())
+			}
+		},
+	},
+	{
+		// Prefixes of other keys.
+		name: "prefixes",
+		newKeys: func(r *rand.Rand) func() []byte {
+			var bases [][]byte
+			return func() []byte {
+				if len(bases) == 0 || r.Intn(2) == 0 {
+					bases = append(bases, randomBytes(r, 1, 12))
+				}
+				b := bases[r.Intn(len(bases))]
+				return append([]byte(nil), b[:r.Intn(len(b))+1]...)
+			}
+		},
+	},
+	{
+		// Clusters of keys which differ from a center key in a single nibble.
+		name: "nibble",
+		newKeys: func(r *rand.Rand) func() []byte {
+			var centers [][]byte
+			return func() []byte {
+				if len(centers) == 0 || r.Intn(8) == 0 {
+					centers = append(centers, randomBytes(r, 8, 40))
+				}
+				k := append([]byte(nil), centers[r.Intn(len(centers))]...)
+				i := r.Intn(len(k))
+				if r.Intn(2) == 0 {
+					k[i] = (k[i] & 0xF0) | byte(r.Intn(16))
+				} else {
+					k[i] = (k[i] & 0x0F) | byte(r.Intn(16)<<4)
+				}
+				return k
+			}
+		},
+	},
+}
+
+// testKeyGenerators returns the key generators selected by -trietest.keys.
+func testKeyGenerators(t *testing.T) []keyGenerator {
+	t.Helper()
+
+	if *keysFlag == "" {
+		return keyGenerators
+	}
+
+	var kgs []keyGenerator
+	for _, name := range strings.Split(*keysFlag, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, kg := range keyGenerators {
+			if kg.name == name {
+				kgs = append(kgs, kg)
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("-trietest.keys: %s is not a key generator", name)
+		}
+	}
+	return kgs
+}
+
+// testKeys calls test with each of the key generators selected by -trietest.keys and logs
+// the generator of each call that fails. With -short, only the first generator is used for
+// more than 2000 keys.
+func testKeys(t *testing.T, n int, test func(kg keyGenerator)) {
+	t.Helper()
+
+	for i, kg := range testKeyGenerators(t) {
+		if i > 0 && n > 2000 && testing.Short() {
+			break
+		}
+
+		failed := t.Failed()
+		test(kg)
+		if !failed && t.Failed() {
+			t.Logf("failed with %s keys: rerun with -trietest.keys=%s", kg.name, kg.name)
+		}
+	}
+}
+
+// generatedKeyValues returns n key/value pairs with keys from kg; the keys are unique.
+func generatedKeyValues(seed int64, n int, kg keyGenerator, minVal, maxVal int) []keyValue {
+	kv := make([]keyValue, 0, n)
+	r := rand.New(rand.NewSource(seed))
+	newKey := kg.newKeys(r)
+	keys := map[string]struct{}{}
+
+	for len(kv) < n {
+		k := newKey()
+		if _, ok := keys[string(k)]; ok {
+			continue
+		}
+		keys[string(k)] = struct{}{}
+
+		kv = append(kv,
+			keyValue{
+				k: k,
+				v: randomBytes(r, minVal, maxVal),
+			})
+	}
+
+	return kv
+}
+
+func TestKeyGenerators(t *testing.T) {
+	for _, kg := range keyGenerators {
+		kv := generatedKeyValues(1, 2000, kg, 1, 8)
+		if len(kv) != 2000 {
+			t.Errorf("generatedKeyValues(%s): got %d keys, want 2000", kg.name, len(kv))
+		}
+
+		if kg.name == "prefixes" {
+			skv := sortKeyValues(kv)
+			var cnt int
+			for i := 1; i < len(skv); i += 1 {
+				if strings.HasPrefix(string(skv[i].k), string(skv[i-1].k)) {
+					cnt += 1
+				}
+			}
+			if cnt < len(skv)/10 {
+				t.Errorf("generatedKeyValues(%s): got %d keys which are prefixes", kg.name, cnt)
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package trietest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+type OpKind int
+
+const (
+	OpDelete OpKind = iota
+	OpGet
+	OpHash
+	OpPut
+	OpSerialize
+)
+
+var opKindNames = []string{
+	OpDelete:    "delete",
+	OpGet:       "get",
+	OpHash:      "hash",
+	OpPut:       "put",
+	OpSerialize: "serialize",
+}
+
+func (ok OpKind) String() string {
+	if ok >= 0 && int(ok) < len(opKindNames) {
+		return opKindNames[ok]
+	}
+	return fmt.Sprintf("op(%d)", int(ok))
+}
+
+// Op is one operation on a trie; Value is only used by OpPut.
+type Op struct {
+	Kind  OpKind
+	Key   []byte
+	Value []byte
+}
+
+func (op Op) String() string {
+	switch op.Kind {
+	case OpDelete, OpGet:
+		return fmt.Sprintf("%s(%x)", op.Kind, op.Key)
+	case OpPut:
+		return fmt.Sprintf("%s(%x, %x)", op.Kind, op.Key, op.Value)
+	}
+	return fmt.Sprintf("%s()", op.Kind)
+}
+
+// Result is the outcome of an Op: the value for OpGet, the hash for OpHash, and the
+// serialized trie for OpSerialize.
+type Result struct {
+	Value []byte
+	Err   error
+}
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return r.Err.Error()
+	} else if r.Value == nil {
+		return "ok"
+	}
+	return fmt.Sprintf("%x", r.Value)
+}
+
+// equal compares the errors of two results by kind: nil, ErrNotFound, ErrNotSupported, or
+// any other error.
+func (r Result) equal(o Result) bool {
+	if errorKind(r.Err) != errorKind(o.Err) {
+		return false
+	}
+	return bytes.Equal(r.Value, o.Value)
+}
+
+func errorKind(err error) int {
+	if err == nil {
+		return 0
+	} else if errors.Is(err, ErrNotFound) {
+		return 1
+	} else if errors.Is(err, ErrNotSupported) {
+		return 2
+	}
+	return 3
+}
+
+// Apply performs op on t.
+func Apply(t Trie, op Op) Result {
+	switch op.Kind {
+	case OpDelete:
+		return Result{Err: t.Delete(op.Key)}
+	case OpGet:
+		val, err := t.Get(op.Key)
+		return Result{Value: val, Err: err}
+	case OpHash:
+		return Result{Value: t.Hash()}
+	case OpPut:
+		return Result{Err: t.Put(op.Key, op.Value)}
+	case OpSerialize:
+		s, err := t.Serialize()
+		return Result{Value: s, Err: err}
+	}
+
+	panic(fmt.Sprintf("trietest: unexpected op: %s", op.Kind))
+}
+
+// NamedTrie is a trie along with the name used to report on it.
+type NamedTrie struct {
+	Name string
+	Trie Trie
+}
+
+// Divergence describes the first place where a trie disagreed with the reference trie.
+// After is true if the tries agreed on the result of the op at Index, but not on the hash
+// after it.
+type Divergence struct {
+	Index int
+	Op    Op
+	After bool
+	Ref   string
+	Want  Result
+	Name  string
+	Got   Result
+}
+
+func (d *Divergence) Error() string {
+	if d.After {
+		return fmt.Sprintf("trietest: op %d: %s: hash after %s: got %s, %s: %s", d.Index,
+			d.Name, d.Op, d.Got, d.Ref, d.Want)
+	} else if d.Op.Kind == OpSerialize && d.Got.Err == nil && d.Want.Err == nil {
+		return fmt.Sprintf("trietest: op %d: %s.%s differs from %s\n%s:\n%s%s:\n%s", d.Index,
+			d.Name, d.Op, d.Ref, d.Ref, indentLines(formatSerialized(d.Want.Value), "    "),
+			d.Name, indentLines(formatSerialized(d.Got.Value), "    "))
+	}
+	return fmt.Sprintf("trietest: op %d: %s.%s: got %s, %s: %s", d.Index, d.Name, d.Op, d.Got,
+		d.Ref, d.Want)
+}
+
+// Lockstep applies ops to tries one at a time, comparing the result of each op against that of
+// the first trie, which is the reference. It returns a *Divergence for the first
+// disagreement. Every trie must support OpDelete if ops contain one, or an OpPut with an empty
+// value, and have CapRandomAccess unless ops only put keys in the order that a trie without it
+// supports, and then hash; OpSerialize is only compared between the tries that support it, and
+// the others must return ErrNotSupported.
+//
+// The hash after each op is compared between the tries with CapIncrementalHash, against the
+// first of them; hashing the others after every op would take time in proportion to the
+// size of the trie for each op, so they are only compared at an OpHash.
+func Lockstep(ops []Op, tries ...NamedTrie) error {
+	err := checkTries(ops, tries)
+	if err != nil {
+		return err
+	}
+
+	for idx, op := range ops {
+		err = lockstep(idx, op, tries)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkTries(ops []Op, tries []NamedTrie) error {
+	if len(tries) == 0 {
+		return errors.New("trietest: need at least one trie")
+	}
+
+	var caps Capabilities
+	var last []byte
+	hashed := false
+	for _, op := range ops {
+		switch op.Kind {
+		case OpDelete:
+			caps |= CapDelete | CapRandomAccess
+		case OpGet:
+			caps |= CapRandomAccess
+		case OpHash:
+			hashed = true
+		case OpPut:
+			if len(op.Value) == 0 {
+				caps |= CapDelete | CapRandomAccess
+			} else if hashed || (last != nil &&
+				(bytes.Compare(op.Key, last) <= 0 || bytes.HasPrefix(op.Key, last))) {
+
+				caps |= CapRandomAccess
+			}
+			last = append([]byte{}, op.Key...)
+		}
+	}
+	for _, nt := range tries {
+		if !nt.Trie.Capabilities().Has(caps) {
+			return fmt.Errorf("trietest: %s does not support %s", nt.Name, caps)
+		}
+	}
+	return nil
+}
+
+func lockstep(idx int, op Op, tries []NamedTrie) error {
+	var ref *NamedTrie
+	var want Result
+	for i := range tries {
+		got := Apply(tries[i].Trie, op)
+		if op.Kind == OpSerialize && !tries[i].Trie.Capabilities().Has(CapSerialize) {
+			if !errors.Is(got.Err, ErrNotSupported) {
+				return &Divergence{
+					Index: idx,
+					Op:    op,
+					Ref:   tries[i].Name,
+					Want:  Result{Err: ErrNotSupported},
+					Name:  tries[i].Name,
+					Got:   got,
+				}
+			}
+			continue
+		}
+
+		if ref == nil {
+			ref = &tries[i]
+			want = got
+		} else if !got.equal(want) {
+			return &Divergence{
+				Index: idx,
+				Op:    op,
+				Ref:   ref.Name,
+				Want:  want,
+				Name:  tries[i].Name,
+				Got:   got,
+			}
+		}
+	}
+
+	if op.Kind == OpHash {
+		return nil
+	}
+	ref = nil
+	var hash []byte
+	for i := range tries {
+		if !tries[i].Trie.Capabilities().Has(CapIncrementalHash) {
+			continue
+		}
+
+		h := tries[i].Trie.Hash()
+		if ref == nil {
+			ref = &tries[i]
+			hash = h
+		} else if !bytes.Equal(h, hash) {
+			return &Divergence{
+				Index: idx,
+				Op:    op,
+				After: true,
+				Ref:   ref.Name,
+				Want:  Result{Value: hash},
+				Name:  tries[i].Name,
+				Got:   Result{Value: h},
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package trietest_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+// skipPutTrie drops the Put of one key, so that it diverges from the other tries.
+type skipPutTrie struct {
+	trietest.Trie
+	skip []byte
+}
+
+func (spt skipPutTrie) Put(key, val []byte) error {
+	if bytes.Equal(key, spt.skip) {
+		return nil
+	}
+	return spt.Trie.Put(key, val)
+}
+
+func TestLockstep(t *testing.T) {
+	ops := []trietest.Op{
+		{Kind: trietest.OpPut, Key: []byte{0x01}, Value: []byte{0x11}},
+		{Kind: trietest.OpPut, Key: []byte{0x01, 0x23}, Value: []byte{0x22}},
+		{Kind: trietest.OpGet, Key: []byte{0x01}},
+		{Kind: trietest.OpGet, Key: []byte{0x02}},
+		{Kind: trietest.OpSerialize},
+		{Kind: trietest.OpPut, Key: []byte{0x12, 0x34}, Value: []byte{0x33}},
+		{Kind: trietest.OpHash},
+	}
+
+	tas := testAdapters(t, 0)
+	err := trietest.Lockstep(ops, testTries(tas)...)
+	if err != nil {
+		t.Errorf("Lockstep() failed with %s", err)
+	}
+
+	inc := testAdapters(t, trietest.CapIncrementalHash)
+	tries := testTries(tas)
+	tries = append(tries,
+		trietest.NamedTrie{
+			Name: "skip",
+			Trie: skipPutTrie{Trie: inc[0].New(), skip: []byte{0x12, 0x34}},
+		})
+	err = trietest.Lockstep(ops, tries...)
+	var d *trietest.Divergence
+	if !errors.As(err, &d) {
+		t.Errorf("Lockstep() with skipped put returned %v, expected divergence", err)
+	} else if d.Index != 5 || d.Name != "skip" || !d.After || d.Ref != inc[0].Name {
+		t.Errorf("Lockstep() with skipped put: got divergence %s", d)
+	}
+
+	tries = testTries(tas)
+	tries = append(tries,
+		trietest.NamedTrie{
+			Name: "skip",
+			Trie: skipPutTrie{Trie: trietest.NewOracleTrie(), skip: []byte{0x12, 0x34}},
+		})
+	err = trietest.Lockstep(ops, tries...)
+	if !errors.As(err, &d) {
+		t.Errorf("Lockstep() with skipped put returned %v, expected divergence", err)
+	} else if d.Index != 6 || d.Name != "skip" || d.After {
+		t.Errorf("Lockstep() with skipped put without incremental hash: got divergence %s", d)
+	}
+
+	tries = testTries(tas)
+	tries = append(tries,
+		trietest.NamedTrie{
+			Name: "skip",
+			Trie: skipPutTrie{Trie: inc[0].New(), skip: []byte{0x01}},
+		})
+	err = trietest.Lockstep(ops, tries...)
+	if !errors.As(err, &d) {
+		t.Errorf("Lockstep() with skipped put returned %v, expected divergence", err)
+	} else if d.Index != 0 || d.Name != "skip" || !d.After {
+		t.Errorf("Lockstep() with skipped put: got divergence %s", d)
+	}
+
+	ops = append(ops, trietest.Op{Kind: trietest.OpDelete, Key: []byte{0x01}})
+	for _, ta := range tas {
+		if ta.New().Capabilities().Has(trietest.CapDelete) {
+			continue
+		}
+		err = trietest.Lockstep(ops, testTries([]trietest.Adapter{ta})...)
+		if err == nil || errors.As(err, &d) {
+			t.Errorf("Lockstep(%s) with delete returned %v, expected not supported", ta.Name,
+				err)
+		}
+	}
+}
@@ -1,25 +1,47 @@
 package trietest
 
 import (
+	"bytes"
+
 	"github.com/leftmike/mptrie"
 )
 
+func init() {
+	Register("mptrie", NewMPTrie)
+	RegisterDeserializer("mptrie", DeserializeMPTrie)
+}
+
 type mpTrie struct {
 	trie *mptrie.MPTrie
+	keys keySet
 }
 
 func NewMPTrie() Trie {
 	return mpTrie{
 		trie: mptrie.New(),
+		keys: keySet{},
 	}
 }
 
+// DeserializeMPTrie rebuilds a trie from the output of its Serialize method.
+func DeserializeMPTrie(b []byte, ns NodeSource) (Trie, error) {
+	return deserialize(NewMPTrie(), b, ns)
+}
+
+func (_ mpTrie) Capabilities() Capabilities {
+	return CapDelete | CapIncrementalHash | CapRandomAccess | CapSerialize
+}
+
 func (mpt mpTrie) Delete(key []byte) error {
 	err := mpt.trie.Delete(key)
 	if err == mptrie.ErrNotFound {
 		return ErrNotFound
+	} else if err != nil {
+		return err
 	}
-	return err
+
+	mpt.keys.remove(key)
+	return nil
 }
 
 func (mpt mpTrie) Get(key []byte) ([]byte, error) {
@@ -34,10 +56,31 @@ func (mpt mpTrie) Hash() []byte {
 	return mpt.trie.Hash()
 }
 
+func (mpt mpTrie) Iterate(start, prefix []byte) Iterator {
+	return mpt.keys.iterate(start, prefix, mpt.Get)
+}
+
+func (mpt mpTrie) Prove(key []byte) ([][]byte, error) {
+	return nil, ErrNotSupported
+}
+
 func (mpt mpTrie) Put(key, val []byte) error {
-	return mpt.trie.Put(key, val)
+	if len(val) == 0 {
+		return ignoreNotFound(mpt.Delete(key))
+	}
+
+	err := mpt.trie.Put(key, val)
+	if err != nil {
+		return err
+	}
+
+	mpt.keys.add(key)
+	return nil
 }
 
-func (mpt mpTrie) Serialize() ([]byte, bool) {
-	return mpt.trie.Encode(), true
+func (mpt mpTrie) Serialize() ([]byte, error) {
+	if bytes.Equal(mpt.Hash(), emptyRoot) {
+		return []byte{0x80}, nil
+	}
+	return mpt.trie.Encode(), nil
 }
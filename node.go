@@ -0,0 +1,394 @@
+package trietest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+// A node is one of *branchNode, *extensionNode, *leafNode, hashNode, or nil for an empty
+// trie or an empty branch slot. Paths are nibbles, one per byte.
+type node interface{}
+
+type branchNode struct {
+	children [16]node
+	value    []byte
+}
+
+type extensionNode struct {
+	path []byte
+	next node
+}
+
+type leafNode struct {
+	path  []byte
+	value []byte
+}
+
+// hashNode is a reference to a node by the keccak256 hash of its encoding.
+type hashNode []byte
+
+var (
+	emptyRoot = keccak256([]byte{0x80})
+
+	errInvalidNode = errors.New("trietest: invalid node")
+)
+
+func keccak256(b []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+func keyToNibbles(key []byte) []byte {
+	nibbles := make([]byte, 0, len(key)*2)
+	for _, b := range key {
+		nibbles = append(nibbles, b>>4, b&0x0F)
+	}
+	return nibbles
+}
+
+func nibblesToKey(nibbles []byte) []byte {
+	key := make([]byte, 0, len(nibbles)/2)
+	for ni := 0; ni+1 < len(nibbles); ni += 2 {
+		key = append(key, (nibbles[ni]<<4)|nibbles[ni+1])
+	}
+	return key
+}
+
+func joinPath(path, rest []byte) []byte {
+	return append(append(make([]byte, 0, len(path)+len(rest)), path...), rest...)
+}
+
+func commonPrefix(a, b []byte) int {
+	var n int
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n += 1
+	}
+	return n
+}
+
+// hexPrefix compacts a path of nibbles into bytes, with a flag in the high nibble of the
+// first byte: 0x20 for a leaf and 0x10 for an odd number of nibbles.
+func hexPrefix(path []byte, leaf bool) []byte {
+	var flag byte
+	if leaf {
+		flag = 0x20
+	}
+
+	hp := make([]byte, 0, len(path)/2+1)
+	if len(path)%2 == 1 {
+		hp = append(hp, flag|0x10|path[0])
+		path = path[1:]
+	} else {
+		hp = append(hp, flag)
+	}
+	return append(hp, nibblesToKey(path)...)
+}
+
+func decodeHexPrefix(hp []byte) ([]byte, bool, error) {
+	if len(hp) == 0 {
+		return nil, false, fmt.Errorf("%w: empty hex prefix path", errInvalidNode)
+	}
+
+	flag := hp[0] >> 4
+	if flag > 3 {
+		return nil, false, fmt.Errorf("%w: hex prefix flag %d", errInvalidNode, flag)
+	}
+
+	var path []byte
+	if flag&0x01 != 0 {
+		path = append(path, hp[0]&0x0F)
+	} else if hp[0]&0x0F != 0 {
+		return nil, false, fmt.Errorf("%w: hex prefix padding %#x", errInvalidNode, hp[0])
+	}
+	return append(path, keyToNibbles(hp[1:])...), flag&0x02 != 0, nil
+}
+
+type nodeKeyValue struct {
+	path []byte
+	val  []byte
+}
+
+// buildTrie builds the nodes of the trie containing the key/value pairs returned by it.
+func buildTrie(it Iterator) (node, error) {
+	var nkv []nodeKeyValue
+	for it.Next() {
+		nkv = append(nkv,
+			nodeKeyValue{
+				path: keyToNibbles(it.Key()),
+				val:  it.Value(),
+			})
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildNode(nkv, 0), nil
+}
+
+// buildNode builds the node at depth for nkv, which must be sorted by path and share the
+// first depth nibbles.
+func buildNode(nkv []nodeKeyValue, depth int) node {
+	if len(nkv) == 0 {
+		return nil
+	} else if len(nkv) == 1 {
+		return &leafNode{
+			path:  nkv[0].path[depth:],
+			value: nkv[0].val,
+		}
+	}
+
+	first := nkv[0].path[depth:]
+	cpl := commonPrefix(first, nkv[len(nkv)-1].path[depth:])
+	if cpl > 0 {
+		return &extensionNode{
+			path: first[:cpl],
+			next: buildNode(nkv, depth+cpl),
+		}
+	}
+
+	var bn branchNode
+	if len(first) == 0 {
+		bn.value = nkv[0].val
+		nkv = nkv[1:]
+	}
+	for len(nkv) > 0 {
+		nibble := nkv[0].path[depth]
+		n := 1
+		for n < len(nkv) && nkv[n].path[depth] == nibble {
+			n += 1
+		}
+
+		bn.children[nibble] = buildNode(nkv[:n], depth+1)
+		nkv = nkv[n:]
+	}
+	return &bn
+}
+
+func encodeNode(n node) []byte {
+	var v interface{}
+	switch n := n.(type) {
+	case *branchNode:
+		var l []interface{}
+		for _, child := range n.children {
+			l = append(l, rlp.RawValue(nodeRef(child)))
+		}
+		v = append(l, n.value)
+	case *extensionNode:
+		v = []interface{}{hexPrefix(n.path, false), rlp.RawValue(nodeRef(n.next))}
+	case *leafNode:
+		v = []interface{}{hexPrefix(n.path, true), n.value}
+	default:
+		panic(fmt.Sprintf("trietest: unexpected node: %T", n))
+	}
+
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		panic(fmt.Sprintf("trietest: encode node: %s", err))
+	}
+	return b
+}
+
+// nodeRef returns the encoding of a reference to n from its parent: the node itself if its
+// encoding is less than 32 bytes, otherwise the hash of its encoding.
+func nodeRef(n node) []byte {
+	var b []byte
+	switch n := n.(type) {
+	case nil:
+		return []byte{0x80}
+	case hashNode:
+		b = n
+	default:
+		enc := encodeNode(n)
+		if len(enc) < 32 {
+			return enc
+		}
+		b = keccak256(enc)
+	}
+
+	ref, err := rlp.EncodeToBytes(b)
+	if err != nil {
+		panic(fmt.Sprintf("trietest: encode node reference: %s", err))
+	}
+	return ref
+}
+
+func hashRoot(root node) []byte {
+	switch root := root.(type) {
+	case nil:
+		return emptyRoot
+	case hashNode:
+		return root
+	default:
+		return keccak256(encodeNode(root))
+	}
+}
+
+func decodeNode(b []byte) (node, error) {
+	elems, rest, err := rlp.SplitList(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidNode, err)
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errInvalidNode, len(rest))
+	}
+
+	cnt, err := rlp.CountValues(elems)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidNode, err)
+	}
+
+	switch cnt {
+	case 2:
+		hp, rest, err := rlp.SplitString(elems)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidNode, err)
+		}
+		path, leaf, err := decodeHexPrefix(hp)
+		if err != nil {
+			return nil, err
+		}
+
+		if leaf {
+			val, _, err := rlp.SplitString(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", errInvalidNode, err)
+			}
+			return &leafNode{
+				path:  path,
+				value: val,
+			}, nil
+		}
+
+		next, _, err := decodeRef(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &extensionNode{
+			path: path,
+			next: next,
+		}, nil
+
+	case 17:
+		var bn branchNode
+		for idx := range bn.children {
+			bn.children[idx], elems, err = decodeRef(elems)
+			if err != nil {
+				return nil, err
+			}
+		}
+		bn.value, _, err = rlp.SplitString(elems)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidNode, err)
+		}
+		if len(bn.value) == 0 {
+			bn.value = nil
+		}
+		return &bn, nil
+	}
+
+	return nil, fmt.Errorf("%w: list of %d elements", errInvalidNode, cnt)
+}
+
+func decodeRef(b []byte) (node, []byte, error) {
+	kind, val, rest, err := rlp.Split(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", errInvalidNode, err)
+	}
+
+	if kind == rlp.List {
+		n, err := decodeNode(b[:len(b)-len(rest)])
+		return n, rest, err
+	} else if len(val) == 0 {
+		return nil, rest, nil
+	} else if len(val) == 32 {
+		return hashNode(val), rest, nil
+	}
+	return nil, nil, fmt.Errorf("%w: reference of %d bytes", errInvalidNode, len(val))
+}
+
+// insertNode puts value at path in the trie rooted at n and returns the new root. It fails if
+// it needs to descend into a hashNode.
+func insertNode(n node, path, value []byte) (node, error) {
+	switch n := n.(type) {
+	case nil:
+		return &leafNode{
+			path:  path,
+			value: value,
+		}, nil
+	case hashNode:
+		return nil, fmt.Errorf("%w: insert into hash node %x", errInvalidNode, []byte(n))
+	case *branchNode:
+		if len(path) == 0 {
+			n.value = value
+			return n, nil
+		}
+
+		child, err := insertNode(n.children[path[0]], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n.children[path[0]] = child
+		return n, nil
+	case *extensionNode:
+		cpl := commonPrefix(n.path, path)
+		if cpl == len(n.path) {
+			next, err := insertNode(n.next, path[cpl:], value)
+			if err != nil {
+				return nil, err
+			}
+			n.next = next
+			return n, nil
+		}
+
+		var bn branchNode
+		if cpl+1 == len(n.path) {
+			bn.children[n.path[cpl]] = n.next
+		} else {
+			bn.children[n.path[cpl]] = &extensionNode{
+				path: n.path[cpl+1:],
+				next: n.next,
+			}
+		}
+		return splitNode(&bn, path[:cpl], path[cpl:], value)
+	case *leafNode:
+		if bytes.Equal(n.path, path) {
+			n.value = value
+			return n, nil
+		}
+
+		cpl := commonPrefix(n.path, path)
+		var bn branchNode
+		if cpl == len(n.path) {
+			bn.value = n.value
+		} else {
+			bn.children[n.path[cpl]] = &leafNode{
+				path:  n.path[cpl+1:],
+				value: n.value,
+			}
+		}
+		return splitNode(&bn, path[:cpl], path[cpl:], value)
+	default:
+		panic(fmt.Sprintf("trietest: unexpected node: %T", n))
+	}
+}
+
+// splitNode inserts value at path into bn, which replaces a node whose path diverged from the
+// path being inserted after prefix.
+func splitNode(bn *branchNode, prefix, path, value []byte) (node, error) {
+	n, err := insertNode(bn, path, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(prefix) > 0 {
+		return &extensionNode{
+			path: prefix,
+			next: n,
+		}, nil
+	}
+	return n, nil
+}
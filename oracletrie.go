@@ -0,0 +1,174 @@
+package trietest
+
+import (
+	"bytes"
+	"sort"
+)
+
+func init() {
+	Register("oracle", NewOracleTrie)
+	RegisterDeserializer("oracle", DeserializeOracleTrie)
+}
+
+// oracleTrie is a reference implementation which does not depend on any of the libraries
+// under test. It keeps its key/value pairs sorted by path, and builds its nodes from them with
+// buildNode every time it is hashed. It is the reference adapter, so Adapters returns it first.
+//
+// The nodes are also built for Prove, ExportNodes, and Serialize; these keep the encodings of
+// the nodes, which are forgotten whenever the trie changes.
+type oracleTrie struct {
+	nkv   []nodeKeyValue    // sorted by path
+	root  []byte            // the encoding of the root node; nil if the trie has changed
+	nodes map[string][]byte // the nodes referenced by hash, by hash
+}
+
+func NewOracleTrie() Trie {
+	return &oracleTrie{}
+}
+
+// DeserializeOracleTrie rebuilds a trie from the output of its Serialize method.
+func DeserializeOracleTrie(b []byte, ns NodeSource) (Trie, error) {
+	return deserialize(NewOracleTrie(), b, ns)
+}
+
+func (_ *oracleTrie) Capabilities() Capabilities {
+	return CapDelete | CapIterate | CapProve | CapRandomAccess | CapSerialize
+}
+
+// search returns the index of path in nkv, or where it would be inserted, and whether it was
+// found.
+func (ot *oracleTrie) search(path []byte) (int, bool) {
+	idx := sort.Search(len(ot.nkv),
+		func(i int) bool {
+			return bytes.Compare(ot.nkv[i].path, path) >= 0
+		})
+	return idx, idx < len(ot.nkv) && bytes.Equal(ot.nkv[idx].path, path)
+}
+
+func (ot *oracleTrie) Delete(key []byte) error {
+	idx, ok := ot.search(keyToNibbles(key))
+	if !ok {
+		return ErrNotFound
+	}
+
+	ot.nkv = append(ot.nkv[:idx], ot.nkv[idx+1:]...)
+	ot.root = nil
+	ot.nodes = nil
+	return nil
+}
+
+// ExportNodes returns the encoding of the root node and a source for the nodes referenced by
+// hash.
+func (ot *oracleTrie) ExportNodes() ([]byte, NodeSource, error) {
+	root, nodes := ot.export()
+	return root, mapNodes(nodes), nil
+}
+
+func (ot *oracleTrie) Get(key []byte) ([]byte, error) {
+	idx, ok := ot.search(keyToNibbles(key))
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ot.nkv[idx].val, nil
+}
+
+func (ot *oracleTrie) Hash() []byte {
+	return hashRoot(buildNode(ot.nkv, 0))
+}
+
+func (ot *oracleTrie) Iterate(start, prefix []byte) Iterator {
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	path := keyToNibbles(prefix)
+
+	var keys []string
+	idx, _ := ot.search(keyToNibbles(start))
+	for ; idx < len(ot.nkv) && bytes.HasPrefix(ot.nkv[idx].path, path); idx += 1 {
+		keys = append(keys, string(nibblesToKey(ot.nkv[idx].path)))
+	}
+
+	return &keySetIterator{
+		keys: keys,
+		get:  ot.Get,
+	}
+}
+
+func (ot *oracleTrie) Prove(key []byte) ([][]byte, error) {
+	root, ns, err := ot.ExportNodes()
+	if err != nil {
+		return nil, err
+	}
+	return proveExported(root, ns, key)
+}
+
+func (ot *oracleTrie) Put(key, val []byte) error {
+	if len(val) == 0 {
+		return ignoreNotFound(ot.Delete(key))
+	}
+
+	path := keyToNibbles(key)
+	val = append([]byte(nil), val...)
+	idx, ok := ot.search(path)
+	if ok {
+		ot.nkv[idx].val = val
+	} else {
+		ot.nkv = append(ot.nkv, nodeKeyValue{})
+		copy(ot.nkv[idx+1:], ot.nkv[idx:])
+		ot.nkv[idx] = nodeKeyValue{path: path, val: val}
+	}
+	ot.root = nil
+	ot.nodes = nil
+	return nil
+}
+
+func (ot *oracleTrie) Serialize() ([]byte, error) {
+	root, _ := ot.export()
+	return root, nil
+}
+
+// export returns the encoding of the root node and the encodings of the nodes referenced by
+// hash, building them if the trie has changed since they were last built.
+func (ot *oracleTrie) export() ([]byte, map[string][]byte) {
+	if ot.root == nil {
+		ot.nodes = map[string][]byte{}
+		switch n := collapseNode(buildNode(ot.nkv, 0), ot.nodes).(type) {
+		case nil:
+			ot.root = []byte{0x80}
+		case hashNode:
+			ot.root = ot.nodes[string(n)]
+		default:
+			ot.root = encodeNode(n)
+		}
+	}
+	return ot.root, ot.nodes
+}
+
+// collapseNode returns n as it is referenced from its parent: a copy of n, with its children
+// collapsed, if its encoding is less than 32 bytes, and otherwise its hashNode, in which case
+// its encoding is added to nodes. Since the children are collapsed first, each node is only
+// encoded once.
+func collapseNode(n node, nodes map[string][]byte) node {
+	switch nn := n.(type) {
+	case nil:
+		return nil
+	case *branchNode:
+		bn := *nn
+		for i := range bn.children {
+			bn.children[i] = collapseNode(bn.children[i], nodes)
+		}
+		n = &bn
+	case *extensionNode:
+		en := *nn
+		en.next = collapseNode(en.next, nodes)
+		n = &en
+	}
+
+	enc := encodeNode(n)
+	if len(enc) < 32 {
+		return n
+	}
+	hash := keccak256(enc)
+	nodes[string(hash)] = enc
+	return hashNode(hash)
+}
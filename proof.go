@@ -0,0 +1,111 @@
+package trietest
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// proveExported returns the encoded nodes on the path to key, following it from root, the
+// encoding of the root node, through the nodes referenced by hash from ns, as returned by
+// ExportNodes: the root node and every node on the path which is referenced by hash. If key is
+// not in the trie, the path ends with the node that shows it is absent. The nodes are in the
+// same order and format as go-ethereum's Trie.Prove.
+func proveExported(root []byte, ns NodeSource, key []byte) ([][]byte, error) {
+	if bytes.Equal(root, []byte{0x80}) {
+		return nil, nil
+	}
+
+	proof := [][]byte{root}
+	n, err := decodeNode(root)
+	if err != nil {
+		return nil, err
+	}
+	path := keyToNibbles(key)
+	for {
+		switch nn := n.(type) {
+		case *branchNode:
+			if len(path) == 0 {
+				return proof, nil
+			}
+			n = nn.children[path[0]]
+			path = path[1:]
+		case *extensionNode:
+			if !bytes.HasPrefix(path, nn.path) {
+				return proof, nil
+			}
+			n = nn.next
+			path = path[len(nn.path):]
+		default:
+			return proof, nil
+		}
+
+		if hn, ok := n.(hashNode); ok {
+			enc, err := ns(hn)
+			if err != nil {
+				return nil, err
+			}
+			proof = append(proof, enc)
+			n, err = decodeNode(enc)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// VerifyProof checks that proof, as returned by Trie.Prove, shows that key has a value in the
+// trie with hash root, and returns the value. If the proof instead shows that key is not in
+// the trie, VerifyProof returns ErrNotFound.
+func VerifyProof(root, key []byte, proof [][]byte) ([]byte, error) {
+	if bytes.Equal(root, emptyRoot) {
+		return nil, ErrNotFound
+	}
+
+	nodes := map[string][]byte{}
+	for _, enc := range proof {
+		nodes[string(keccak256(enc))] = enc
+	}
+
+	path := keyToNibbles(key)
+	hash := root
+	for {
+		enc, ok := nodes[string(hash)]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing node %x", ErrInvalidProof, hash)
+		}
+		n, err := decodeNode(enc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+		}
+
+		hash = nil
+		for hash == nil {
+			switch nn := n.(type) {
+			case nil:
+				return nil, ErrNotFound
+			case hashNode:
+				hash = nn
+			case *branchNode:
+				if len(path) == 0 {
+					if nn.value == nil {
+						return nil, ErrNotFound
+					}
+					return nn.value, nil
+				}
+				n = nn.children[path[0]]
+				path = path[1:]
+			case *extensionNode:
+				if !bytes.HasPrefix(path, nn.path) {
+					return nil, ErrNotFound
+				}
+				n = nn.next
+				path = path[len(nn.path):]
+			case *leafNode:
+				if !bytes.Equal(path, nn.path) {
+					return nil, ErrNotFound
+				}
+				return nn.value, nil
+			}
+		}
+	}
+}
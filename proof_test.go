@@ -0,0 +1,386 @@
+package trietest_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	ethtrie "github.com/ethereum/go-ethereum/trie"
+	"github.com/leftmike/trietest"
+)
+
+// proofKeyValues returns the key/value pairs to prove: building a proof can take time
+// proportional to the size of the trie, so only a sample of the keys are proved.
+func proofKeyValues(kv []keyValue) []keyValue {
+	if len(kv) > 5 {
+		return kv[:5]
+	}
+	return kv
+}
+
+// testProveTrie proves k and checks that the proof verifies to v, or that k is absent if v is
+// nil.
+func testProveTrie(t *testing.T, who string, trie trietest.Trie, k, v []byte) [][]byte {
+	t.Helper()
+
+	proof, err := trie.Prove(k)
+	if err != nil {
+		t.Errorf("%s.Prove(%#v) failed with %s", who, k, err)
+		return nil
+	}
+
+	val, err := trietest.VerifyProof(trie.Hash(), k, proof)
+	if v == nil {
+		if err != trietest.ErrNotFound {
+			t.Errorf("VerifyProof(%s.Prove(%#v)) returned %v, expected not found", who, k, err)
+		}
+	} else if err != nil {
+		t.Errorf("VerifyProof(%s.Prove(%#v)) failed with %s", who, k, err)
+	} else if !bytes.Equal(val, v) {
+		t.Errorf("VerifyProof(%s.Prove(%#v)): got %#v, want %#v", who, k, val, v)
+	}
+	return proof
+}
+
+func absentKeyValues(kv []keyValue) []keyValue {
+	akv := make([]keyValue, 0, len(kv))
+	for _, kv := range kv {
+		akv = append(akv, keyValue{k: kv.k})
+	}
+	return akv
+}
+
+func testEqualProofs(t *testing.T, who string, k []byte, proof, want [][]byte) {
+	t.Helper()
+
+	if len(proof) != len(want) {
+		t.Errorf("%s.Prove(%#v): got %d nodes, want %d", who, k, len(proof), len(want))
+		return
+	}
+
+	for i := range want {
+		if !bytes.Equal(proof[i], want[i]) {
+			t.Errorf("%s.Prove(%#v)[%d]: got %#v, want %#v", who, k, i, proof[i], want[i])
+		}
+	}
+}
+
+func testProveKeys(t *testing.T, who string, trie trietest.Trie, kv []keyValue) [][][]byte {
+	t.Helper()
+
+	var proofs [][][]byte
+	for _, kv := range proofKeyValues(kv) {
+		proofs = append(proofs, testProveTrie(t, who, trie, kv.k, kv.v))
+	}
+	return proofs
+}
+
+func testProofsTrie(t *testing.T, who string, trie trietest.Trie, kv []keyValue,
+	proofs [][][]byte) {
+
+	t.Helper()
+
+	for i, kv := range proofKeyValues(kv) {
+		proof := testProveTrie(t, who, trie, kv.k, kv.v)
+		testEqualProofs(t, who, kv.k, proof, proofs[i])
+	}
+}
+
+// testProofsTries checks that each of tries which supports Prove proves kv the same as the
+// first of them.
+func testProofsTries(t *testing.T, tries []trietest.NamedTrie, kv []keyValue) {
+	t.Helper()
+
+	var proofs [][][]byte
+	var proved bool
+	for _, nt := range tries {
+		if !nt.Trie.Capabilities().Has(trietest.CapProve) {
+			continue
+		}
+		if !proved {
+			proofs = testProveKeys(t, nt.Name, nt.Trie, kv)
+			proved = true
+		} else {
+			testProofsTrie(t, nt.Name, nt.Trie, kv, proofs)
+		}
+	}
+}
+
+func testProve(t *testing.T, who string, newTrie func() trietest.Trie) {
+	t.Helper()
+
+	key1 := []byte{0x00, 0x12, 0x34}
+	val1 := []byte{0x01, 0x23, 0x45}
+	key2 := []byte{0xA0, 0x12, 0x34}
+	val2 := []byte{0xA1, 0x23, 0x45}
+
+	trie := newTrie()
+	testPutTrie(t, who, trie, key1, val1)
+	testEqualProofs(t, who, key1, testProveTrie(t, who, trie, key1, val1),
+		[][]byte{{0xc9, 0x84, 0x20, 0x0, 0x12, 0x34, 0x83, 0x1, 0x23, 0x45}})
+
+	testPutTrie(t, who, trie, key2, val2)
+	want := [][]byte{
+		{0xe1, 0xc8, 0x83, 0x30, 0x12, 0x34, 0x83, 0x1, 0x23, 0x45, 0x80, 0x80, 0x80, 0x80,
+			0x80, 0x80, 0x80, 0x80, 0x80, 0xc8, 0x83, 0x30, 0x12, 0x34, 0x83, 0xa1, 0x23, 0x45,
+			0x80, 0x80, 0x80, 0x80, 0x80, 0x80},
+	}
+	testEqualProofs(t, who, key1, testProveTrie(t, who, trie, key1, val1), want)
+	testEqualProofs(t, who, key2, testProveTrie(t, who, trie, key2, val2), want)
+
+	testEqualProofs(t, who, []byte{0x00, 0x12},
+		testProveTrie(t, who, trie, []byte{0x00, 0x12}, nil), want)
+	testEqualProofs(t, who, []byte{0x50, 0x12, 0x34},
+		testProveTrie(t, who, trie, []byte{0x50, 0x12, 0x34}, nil), want)
+	testEqualProofs(t, who, []byte{0x00, 0x12, 0x35},
+		testProveTrie(t, who, trie, []byte{0x00, 0x12, 0x35}, nil), want)
+	testEqualProofs(t, who, []byte{0x00, 0x12, 0x34, 0x56},
+		testProveTrie(t, who, trie, []byte{0x00, 0x12, 0x34, 0x56}, nil), want)
+
+	key3 := []byte{0x00, 0x12, 0x35}
+	val3 := []byte{0x01, 0x23, 0x46}
+	testPutTrie(t, who, trie, key3, val3)
+	testProveTrie(t, who, trie, key1, val1)
+	testProveTrie(t, who, trie, key3, val3)
+	testProveTrie(t, who, trie, []byte{0x00, 0x12, 0x36}, nil)
+	testProveTrie(t, who, trie, []byte{0x00, 0x13}, nil)
+	testProveTrie(t, who, trie, []byte{0x00, 0x12, 0x34, 0x00}, nil)
+	testProveTrie(t, who, trie, []byte{0xA0}, nil)
+
+	testEqualProofs(t, who, key1, testProveTrie(t, who, newTrie(), key1, nil), nil)
+
+	kv := randomKeyValues(1, 200, 1, 64, 1, 128)
+	trie = newTrie()
+	for _, kv := range kv {
+		testPutTrie(t, who, trie, kv.k, kv.v)
+	}
+
+	root := trie.Hash()
+	proof := testProveTrie(t, who, trie, kv[0].k, kv[0].v)
+	if len(proof) < 2 {
+		t.Fatalf("%s.Prove(%#v): got %d nodes, want at least 2", who, kv[0].k, len(proof))
+	}
+
+	_, err := trietest.VerifyProof(root, kv[1].k, proof)
+	if !errors.Is(err, trietest.ErrInvalidProof) {
+		t.Errorf("VerifyProof(%#v) with proof of %#v returned %v, expected invalid proof",
+			kv[1].k, kv[0].k, err)
+	}
+
+	_, err = trietest.VerifyProof(root, kv[0].k, proof[:len(proof)-1])
+	if !errors.Is(err, trietest.ErrInvalidProof) {
+		t.Errorf("VerifyProof(%#v) with truncated proof returned %v, expected invalid proof",
+			kv[0].k, err)
+	}
+
+	bad := append([][]byte(nil), proof...)
+	bad[len(bad)-1] = append([]byte(nil), bad[len(bad)-1]...)
+	bad[len(bad)-1][len(bad[len(bad)-1])-1] ^= 0xFF
+	_, err = trietest.VerifyProof(root, kv[0].k, bad)
+	if !errors.Is(err, trietest.ErrInvalidProof) {
+		t.Errorf("VerifyProof(%#v) with modified proof returned %v, expected invalid proof",
+			kv[0].k, err)
+	}
+}
+
+func TestProve(t *testing.T) {
+	suiteProve(t, testAdapters(t, trietest.CapProve))
+}
+
+func suiteProve(t *testing.T, tas []trietest.Adapter) {
+	for _, ta := range tas {
+		testProve(t, ta.Name, ta.New)
+	}
+}
+
+func rangeKeyValues(skv []keyValue, start, end []byte, limit int) ([]keyValue, bool) {
+	var kv []keyValue
+	for i := range skv {
+		if bytes.Compare(skv[i].k, start) < 0 {
+			continue
+		}
+		if limit > 0 && len(kv) == limit {
+			return kv, true
+		}
+
+		kv = append(kv, skv[i])
+		if end != nil && bytes.Compare(skv[i].k, end) >= 0 {
+			return kv, i < len(skv)-1
+		}
+	}
+	return kv, false
+}
+
+func testProveRangeTrie(t *testing.T, who string, trie trietest.Trie, skv []keyValue, start,
+	end []byte, limit int) *trietest.RangeProof {
+
+	t.Helper()
+
+	rp, err := trietest.ProveRange(trie, start, end, limit)
+	if err != nil {
+		t.Errorf("ProveRange(%s, %#v, %#v, %d) failed with %s", who, start, end, limit, err)
+		return nil
+	}
+
+	kv, more := rangeKeyValues(skv, start, end, limit)
+	if len(rp.Keys) != len(kv) || len(rp.Values) != len(kv) {
+		t.Errorf("ProveRange(%s, %#v, %#v, %d): got %d keys and %d values, want %d", who,
+			start, end, limit, len(rp.Keys), len(rp.Values), len(kv))
+		return nil
+	}
+	for i := range kv {
+		if !bytes.Equal(rp.Keys[i], kv[i].k) || !bytes.Equal(rp.Values[i], kv[i].v) {
+			t.Errorf("ProveRange(%s, %#v, %#v, %d)[%d]: got %#v: %#v, want %#v: %#v", who,
+				start, end, limit, i, rp.Keys[i], rp.Values[i], kv[i].k, kv[i].v)
+			return nil
+		}
+	}
+
+	m, err := trietest.VerifyRangeProof(trie.Hash(), start, rp.Keys, rp.Values, rp.Proof)
+	if err != nil {
+		t.Errorf("VerifyRangeProof(ProveRange(%s, %#v, %#v, %d)) failed with %s", who, start,
+			end, limit, err)
+	} else if m != more {
+		t.Errorf("VerifyRangeProof(ProveRange(%s, %#v, %#v, %d)): got more %v, want %v", who,
+			start, end, limit, m, more)
+	}
+	return rp
+}
+
+func testEqualRangeProofs(t *testing.T, who string, start []byte, rp, want *trietest.RangeProof) {
+	t.Helper()
+
+	if rp == nil || want == nil {
+		return
+	}
+	testEqualProofs(t, who, start, rp.Proof, want.Proof)
+}
+
+// ethVerifyRangeProof calls go-ethereum's VerifyRangeProof, which is for tries whose keys are
+// all the same length, as in snap sync: with keys of different lengths, it rejects edge keys
+// of different lengths, and can panic when a key is a prefix of another.
+func ethVerifyRangeProof(root, start []byte, rp *trietest.RangeProof) (bool, error) {
+	db := memorydb.New()
+	for _, enc := range rp.Proof {
+		db.Put(crypto.Keccak256(enc), enc)
+	}
+
+	_, _, _, more, err := ethtrie.VerifyRangeProof(common.BytesToHash(root), start,
+		rp.Keys[len(rp.Keys)-1], rp.Keys, rp.Values, db)
+	return more, err
+}
+
+func testRangeProofs(t *testing.T, tas []trietest.Adapter, seed int64, n, minKey, maxKey int) {
+	t.Helper()
+
+	kv := randomKeyValues(seed, n, minKey, maxKey, 1, 64)
+	skv := sortKeyValues(kv)
+	r := rand.New(rand.NewSource(seed))
+	eth := minKey == maxKey
+
+	tries := testTries(tas)
+	for _, nt := range tries {
+		for _, kv := range kv {
+			testPutTrie(t, nt.Name, nt.Trie, kv.k, kv.v)
+		}
+	}
+	root := tries[0].Trie.Hash()
+
+	for i := 0; i < 20; i += 1 {
+		start := skv[r.Intn(len(skv))].k
+		if i%2 == 1 {
+			start = randomBytes(r, minKey, maxKey)
+		}
+		var end []byte
+		if i%3 == 1 {
+			end = skv[r.Intn(len(skv))].k
+		} else if i%3 == 2 {
+			end = randomBytes(r, minKey, maxKey)
+		}
+		limit := []int{0, 1, 2, 10, 100}[i%5]
+
+		want := testProveRangeTrie(t, tries[0].Name, tries[0].Trie, skv, start, end, limit)
+		for _, nt := range tries[1:] {
+			rp := testProveRangeTrie(t, nt.Name, nt.Trie, skv, start, end, limit)
+			testEqualRangeProofs(t, nt.Name, start, rp, want)
+		}
+		if want == nil || len(want.Keys) == 0 {
+			continue
+		}
+
+		if eth {
+			m, err := trietest.VerifyRangeProof(root, start, want.Keys, want.Values, want.Proof)
+			em, eerr := ethVerifyRangeProof(root, start, want)
+			if (err == nil) != (eerr == nil) {
+				t.Errorf("VerifyRangeProof(%#v): got %v, eth got %v", start, err, eerr)
+			} else if eerr != nil {
+				t.Errorf("eth VerifyRangeProof(%#v) failed with %s", start, eerr)
+			} else if m != em {
+				t.Errorf("VerifyRangeProof(%#v): got more %v, eth got %v", start, m, em)
+			}
+		}
+
+		if len(want.Keys) > 2 {
+			bad := *want
+			bad.Keys = append(append([][]byte(nil), want.Keys[:1]...), want.Keys[2:]...)
+			bad.Values = append(append([][]byte(nil), want.Values[:1]...), want.Values[2:]...)
+			_, err := trietest.VerifyRangeProof(root, start, bad.Keys, bad.Values, bad.Proof)
+			if !errors.Is(err, trietest.ErrInvalidProof) {
+				t.Errorf("VerifyRangeProof(%#v) with a missing key returned %v, expected invalid "+
+					"proof", start, err)
+			}
+			if eth {
+				if _, err := ethVerifyRangeProof(root, start, &bad); err == nil {
+					t.Errorf("eth VerifyRangeProof(%#v) with a missing key did not fail", start)
+				}
+			}
+		}
+
+		bad := *want
+		bad.Values = append([][]byte(nil), want.Values...)
+		bad.Values[0] = append(append([]byte(nil), want.Values[0]...), 0xFF)
+		_, err := trietest.VerifyRangeProof(root, start, bad.Keys, bad.Values, bad.Proof)
+		if !errors.Is(err, trietest.ErrInvalidProof) {
+			t.Errorf("VerifyRangeProof(%#v) with a modified value returned %v, expected invalid "+
+				"proof", start, err)
+		}
+		if eth {
+			if _, err := ethVerifyRangeProof(root, start, &bad); err == nil {
+				t.Errorf("eth VerifyRangeProof(%#v) with a modified value did not fail", start)
+			}
+		}
+	}
+
+	all, err := trietest.ProveRange(tries[0].Trie, nil, nil, 0)
+	if err != nil {
+		t.Fatalf("ProveRange(%s, nil, nil, 0) failed with %s", tries[0].Name, err)
+	}
+	more, err := trietest.VerifyRangeProof(root, nil, all.Keys, all.Values, nil)
+	if err != nil {
+		t.Errorf("VerifyRangeProof(all keys) failed with %s", err)
+	} else if more {
+		t.Errorf("VerifyRangeProof(all keys): got more %v, want false", more)
+	}
+
+	_, err = trietest.VerifyRangeProof(root, nil, all.Keys[1:], all.Values[1:], nil)
+	if !errors.Is(err, trietest.ErrInvalidProof) {
+		t.Errorf("VerifyRangeProof(all but one key) returned %v, expected invalid proof", err)
+	}
+}
+
+func TestRangeProof(t *testing.T) {
+	suiteRangeProof(t, testAdapters(t, trietest.CapProve))
+}
+
+func suiteRangeProof(t *testing.T, tas []trietest.Adapter) {
+	testRandom(t, []int{1, 2, 20, 200, 2000},
+		func(seed int64, n int) {
+			testRangeProofs(t, tas, seed, n, 32, 32)
+			testRangeProofs(t, tas, seed, n, 1, 4)
+			testRangeProofs(t, tas, seed, n, 1, 64)
+		})
+}
@@ -0,0 +1,230 @@
+package trietest
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RangeProof is a contiguous range of the key/value pairs in a trie, along with the nodes on
+// the paths to the edges of the range.
+type RangeProof struct {
+	Keys   [][]byte
+	Values [][]byte
+	Proof  [][]byte
+}
+
+// ProveRange returns the key/value pairs of t from start through the first key which is at
+// least end, if there is one, but no more than limit of them if limit is greater than zero; a
+// nil end means no end. As in snap sync, returning the first key after end, unless end itself
+// is a key, shows that there are no other keys through end.
+func ProveRange(t Trie, start, end []byte, limit int) (*RangeProof, error) {
+	var rp RangeProof
+	it := t.Iterate(start, nil)
+	for (limit <= 0 || len(rp.Keys) < limit) && it.Next() {
+		rp.Keys = append(rp.Keys, it.Key())
+		rp.Values = append(rp.Values, it.Value())
+		if end != nil && bytes.Compare(it.Key(), end) >= 0 {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	proof, err := t.Prove(start)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rp.Keys) > 0 {
+		last, err := t.Prove(rp.Keys[len(rp.Keys)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		nodes := map[string]struct{}{}
+		for _, enc := range proof {
+			nodes[string(enc)] = struct{}{}
+		}
+		for _, enc := range last {
+			if _, ok := nodes[string(enc)]; !ok {
+				proof = append(proof, enc)
+			}
+		}
+	}
+
+	rp.Proof = proof
+	return &rp, nil
+}
+
+// VerifyRangeProof checks that keys and values, with the edge nodes in proof, are all of the
+// key/value pairs from start through the last key in the trie with hash root. It returns true
+// if the trie has more keys after the last key. If keys is empty, proof must show that the
+// trie has no keys from start on. If proof is empty, keys must be all of the keys in the trie.
+func VerifyRangeProof(root, start []byte, keys, values, proof [][]byte) (bool, error) {
+	if len(keys) != len(values) {
+		return false, fmt.Errorf("%w: %d keys and %d values", ErrInvalidProof, len(keys),
+			len(values))
+	}
+	for i := range keys {
+		if len(values[i]) == 0 {
+			return false, fmt.Errorf("%w: empty value for key %x", ErrInvalidProof, keys[i])
+		} else if i == 0 {
+			if bytes.Compare(keys[i], start) < 0 {
+				return false, fmt.Errorf("%w: key %x is before start %x", ErrInvalidProof,
+					keys[i], start)
+			}
+		} else if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			return false, fmt.Errorf("%w: keys %x and %x are out of order", ErrInvalidProof,
+				keys[i-1], keys[i])
+		}
+	}
+
+	var n node
+	rv := rangeVerifier{
+		nodes: map[string][]byte{},
+		start: keyToNibbles(start),
+	}
+	if len(proof) > 0 {
+		for _, enc := range proof {
+			rv.nodes[string(keccak256(enc))] = enc
+		}
+		if len(keys) > 0 {
+			rv.end = keyToNibbles(keys[len(keys)-1])
+		}
+
+		var err error
+		n, err = rv.prune(hashNode(root), nil)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for i := range keys {
+		var err error
+		n, err = insertNode(n, keyToNibbles(keys[i]), values[i])
+		if err != nil {
+			return false, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+		}
+	}
+
+	if h := hashRoot(n); !bytes.Equal(h, root) {
+		return false, fmt.Errorf("%w: got hash %x, want %x", ErrInvalidProof, h, root)
+	}
+	return rv.more, nil
+}
+
+type rangePosition int
+
+const (
+	rangeBefore rangePosition = iota
+	rangeOverlap
+	rangeInside
+	rangeAfter
+)
+
+// rangeVerifier removes the keys from start through end (nibble paths) from the trie built
+// from the edge nodes of a range proof, leaving the parts of the trie outside of the range;
+// a nil end means no end.
+type rangeVerifier struct {
+	nodes map[string][]byte
+	start []byte
+	end   []byte
+	more  bool
+}
+
+// subtree returns where the keys with prefix path are relative to the range.
+func (rv *rangeVerifier) subtree(path []byte) rangePosition {
+	if bytes.Compare(path, rv.start) < 0 {
+		if bytes.HasPrefix(rv.start, path) {
+			return rangeOverlap
+		}
+		return rangeBefore
+	} else if rv.end == nil {
+		return rangeInside
+	} else if bytes.Compare(path, rv.end) > 0 {
+		return rangeAfter
+	} else if bytes.HasPrefix(rv.end, path) {
+		return rangeOverlap
+	}
+	return rangeInside
+}
+
+// key returns where the key with path is relative to the range.
+func (rv *rangeVerifier) key(path []byte) rangePosition {
+	if bytes.Compare(path, rv.start) < 0 {
+		return rangeBefore
+	} else if rv.end != nil && bytes.Compare(path, rv.end) > 0 {
+		return rangeAfter
+	}
+	return rangeInside
+}
+
+func (rv *rangeVerifier) prune(n node, path []byte) (node, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	switch rv.subtree(path) {
+	case rangeBefore:
+		return n, nil
+	case rangeInside:
+		return nil, nil
+	case rangeAfter:
+		rv.more = true
+		return n, nil
+	}
+
+	if hn, ok := n.(hashNode); ok {
+		enc, ok := rv.nodes[string(hn)]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing node %x", ErrInvalidProof, []byte(hn))
+		}
+
+		var err error
+		n, err = decodeNode(enc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+		}
+	}
+
+	switch nn := n.(type) {
+	case *branchNode:
+		if nn.value != nil {
+			switch rv.key(path) {
+			case rangeInside:
+				nn.value = nil
+			case rangeAfter:
+				rv.more = true
+			}
+		}
+
+		for idx := range nn.children {
+			child, err := rv.prune(nn.children[idx], joinPath(path, []byte{byte(idx)}))
+			if err != nil {
+				return nil, err
+			}
+			nn.children[idx] = child
+		}
+		return nn, nil
+	case *extensionNode:
+		next, err := rv.prune(nn.next, joinPath(path, nn.path))
+		if err != nil {
+			return nil, err
+		} else if next == nil {
+			return nil, nil
+		}
+		nn.next = next
+		return nn, nil
+	case *leafNode:
+		switch rv.key(joinPath(path, nn.path)) {
+		case rangeInside:
+			return nil, nil
+		case rangeAfter:
+			rv.more = true
+		}
+		return nn, nil
+	}
+
+	panic(fmt.Sprintf("trietest: unexpected node: %T", n))
+}
@@ -0,0 +1,85 @@
+package trietest
+
+import (
+	"fmt"
+)
+
+// Factory returns a new empty Trie.
+type Factory func() Trie
+
+// Deserializer rebuilds a Trie from the output of its Serialize method, getting the nodes
+// referenced by hash from ns. Serialize only encodes the root node, so ns is not part of the
+// serialized trie: it must be supplied by the caller, from ExportNodes of a NodeExporter or
+// from ProofNodes of the proofs of all of the keys. The tests supply it from the proofs of the
+// trie if it has CapProve, and otherwise from an oracle trie with the same keys; so the mptrie
+// adapter, which can neither prove nor export its nodes, is only rebuilt from nodes of
+// another trie.
+type Deserializer func(b []byte, ns NodeSource) (Trie, error)
+
+// Adapter is a registered Trie implementation. Deserialize is nil unless the implementation
+// supports it.
+type Adapter struct {
+	Name        string
+	New         Factory
+	Deserialize Deserializer
+}
+
+// referenceAdapter is the name of the adapter which the others are compared against.
+const referenceAdapter = "oracle"
+
+var adapters []Adapter
+
+func lookupAdapter(name string) int {
+	for i := range adapters {
+		if adapters[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Register adds a Trie implementation with name. It panics if name is already registered.
+func Register(name string, factory Factory) {
+	if lookupAdapter(name) >= 0 {
+		panic(fmt.Sprintf("trietest: adapter %s already registered", name))
+	}
+
+	adapters = append(adapters,
+		Adapter{
+			Name: name,
+			New:  factory,
+		})
+}
+
+// RegisterDeserializer adds a Deserializer to the Trie implementation registered with name.
+func RegisterDeserializer(name string, deserialize Deserializer) {
+	i := lookupAdapter(name)
+	if i < 0 {
+		panic(fmt.Sprintf("trietest: adapter %s not registered", name))
+	}
+	adapters[i].Deserialize = deserialize
+}
+
+// Adapters returns the registered Trie implementations: the reference adapter first, and then
+// the others in the order they were registered.
+func Adapters() []Adapter {
+	tas := make([]Adapter, 0, len(adapters))
+	if i := lookupAdapter(referenceAdapter); i >= 0 {
+		tas = append(tas, adapters[i])
+	}
+	for _, ta := range adapters {
+		if ta.Name != referenceAdapter {
+			tas = append(tas, ta)
+		}
+	}
+	return tas
+}
+
+// LookupAdapter returns the Trie implementation registered with name.
+func LookupAdapter(name string) (Adapter, bool) {
+	i := lookupAdapter(name)
+	if i < 0 {
+		return Adapter{}, false
+	}
+	return adapters[i], true
+}
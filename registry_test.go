@@ -0,0 +1,46 @@
+package trietest_test
+
+import (
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+func TestRegister(t *testing.T) {
+	var names []string
+	for _, ta := range trietest.Adapters() {
+		names = append(names, ta.Name)
+		if ta.New == nil {
+			t.Fatalf("Adapters(): %s has no factory", ta.Name)
+		}
+	}
+	if len(names) == 0 || names[0] != "oracle" {
+		t.Errorf("Adapters(): got %v, want oracle first", names)
+	}
+
+	for _, ta := range selectAdapters(t, 0) {
+		if ta.New().Capabilities().Has(trietest.CapSerialize) != (ta.Deserialize != nil) {
+			t.Errorf("Adapters(): %s has capabilities %s but deserializer %v", ta.Name,
+				ta.New().Capabilities(), ta.Deserialize != nil)
+		}
+	}
+
+	for _, name := range []string{"eth", "mptrie", "oracle", "stacktrie", "zhang"} {
+		ta, ok := trietest.LookupAdapter(name)
+		if !ok || ta.Name != name {
+			t.Errorf("LookupAdapter(%s) failed; registered adapters: %v", name, names)
+		}
+	}
+	if _, ok := trietest.LookupAdapter("missing"); ok {
+		t.Errorf("LookupAdapter(missing) did not fail")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Register(eth) twice did not panic")
+			}
+		}()
+		trietest.Register("eth", trietest.NewEthTrie)
+	}()
+}
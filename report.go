@@ -0,0 +1,306 @@
+package trietest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Outcome is the result of running a suite against an adapter.
+type Outcome int
+
+const (
+	OutcomePass Outcome = iota
+	OutcomeFail
+	OutcomeUnsupported
+	OutcomeSkipped
+)
+
+var outcomeNames = []string{"pass", "fail", "unsupported", "skipped"}
+
+func (o Outcome) String() string {
+	if o < 0 || int(o) >= len(outcomeNames) {
+		return fmt.Sprintf("outcome(%d)", int(o))
+	}
+	return outcomeNames[o]
+}
+
+func (o Outcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+func (o *Outcome) UnmarshalText(b []byte) error {
+	for oi, name := range outcomeNames {
+		if name == string(b) {
+			*o = Outcome(oi)
+			return nil
+		}
+	}
+	return fmt.Errorf("trietest: unknown outcome: %s", b)
+}
+
+// ReportCell is the outcome of one suite for one adapter. Tests and Failed count the tests of
+// the suite which ran and which failed, and Skipped the tests which were skipped; a suite
+// whose tests were all skipped has an outcome of skipped. Reference is true if the adapter
+// was the reference for the suite, so it was not compared against another adapter. Message
+// is the first message of the first test which failed, or the reason the suite is
+// unsupported.
+type ReportCell struct {
+	Suite     string  `json:"suite"`
+	Outcome   Outcome `json:"outcome"`
+	Tests     int     `json:"tests"`
+	Failed    int     `json:"failed"`
+	Skipped   int     `json:"skipped"`
+	Reference bool    `json:"reference,omitempty"`
+	Message   string  `json:"message,omitempty"`
+}
+
+// ReportRow is the outcomes of the suites, in the order of Report.Suites, for one adapter,
+// along with the number of suites with each outcome.
+type ReportRow struct {
+	Adapter     string       `json:"adapter"`
+	Cells       []ReportCell `json:"cells"`
+	Pass        int          `json:"pass"`
+	Fail        int          `json:"fail"`
+	Unsupported int          `json:"unsupported"`
+	Skipped     int          `json:"skipped"`
+}
+
+// Report is a conformance matrix of adapters by suites.
+type Report struct {
+	Suites []string    `json:"suites"`
+	Rows   []ReportRow `json:"adapters"`
+}
+
+// ReferenceTest is the name of a subtest which TestConformance runs in a suite for the
+// adapter which is the reference for that suite: since the suite ran without another adapter
+// to compare against, its cell in the report is marked as the reference.
+const ReferenceTest = "reference"
+
+type testEvent struct {
+	Action string
+	Test   string
+	Output string
+}
+
+var messageRegexp = regexp.MustCompile(`^\s+\S+\.go:\d+: `)
+
+// ReadTestEvents reads the output of go test -json from r and returns a report of the
+// subtests of test, which must be named test/<adapter>/<suite>/<test>; a suite which is
+// skipped is unsupported, and a test named ReferenceTest marks the adapter as the reference
+// for the suite. Adapters and suites are in the order that they first ran.
+func ReadTestEvents(r io.Reader, test string) (*Report, error) {
+	var rpt Report
+	rows := map[string]int{}
+	cells := map[string]*ReportCell{}
+	messages := map[string]string{}
+
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1<<24)
+	for ln := 1; scan.Scan(); ln += 1 {
+		b := bytes.TrimSpace(scan.Bytes())
+		if len(b) == 0 || b[0] != '{' {
+			continue
+		}
+
+		var te testEvent
+		err := json.Unmarshal(b, &te)
+		if err != nil {
+			return nil, fmt.Errorf("trietest: test event line %d: %s", ln, err)
+		}
+		names := strings.SplitN(te.Test, "/", 5)
+		if len(names) < 3 || names[0] != test {
+			continue
+		}
+		adapter, suite := names[1], names[2]
+
+		key := adapter + "/" + suite
+		rc, ok := cells[key]
+		if !ok {
+			ri, ok := rows[adapter]
+			if !ok {
+				ri = len(rpt.Rows)
+				rows[adapter] = ri
+				rpt.Rows = append(rpt.Rows, ReportRow{Adapter: adapter})
+			}
+			if !containsString(rpt.Suites, suite) {
+				rpt.Suites = append(rpt.Suites, suite)
+			}
+			rc = &ReportCell{Suite: suite}
+			cells[key] = rc
+		}
+
+		if te.Action == "output" && messageRegexp.MatchString(te.Output) {
+			if _, ok := messages[te.Test]; !ok {
+				messages[te.Test] = strings.TrimSpace(te.Output)
+			}
+		}
+		if len(names) == 3 {
+			if te.Action == "skip" {
+				rc.Outcome = OutcomeUnsupported
+				rc.Message = messages[te.Test]
+			}
+		} else if len(names) == 4 && names[3] == ReferenceTest {
+			if te.Action == "pass" {
+				rc.Reference = true
+			}
+		} else if len(names) == 4 {
+			switch te.Action {
+			case "pass":
+				rc.Tests += 1
+			case "skip":
+				rc.Skipped += 1
+			case "fail":
+				rc.Tests += 1
+				rc.Failed += 1
+				if rc.Outcome != OutcomeFail {
+					rc.Outcome = OutcomeFail
+					rc.Message = names[3] + ": " + messages[te.Test]
+				}
+			}
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	for ri := range rpt.Rows {
+		row := &rpt.Rows[ri]
+		for _, suite := range rpt.Suites {
+			rc, ok := cells[row.Adapter+"/"+suite]
+			if !ok {
+				continue
+			}
+			if rc.Outcome == OutcomePass && rc.Tests == 0 && rc.Skipped > 0 {
+				rc.Outcome = OutcomeSkipped
+			}
+			row.Cells = append(row.Cells, *rc)
+			switch rc.Outcome {
+			case OutcomePass:
+				row.Pass += 1
+			case OutcomeFail:
+				row.Fail += 1
+			case OutcomeUnsupported:
+				row.Unsupported += 1
+			case OutcomeSkipped:
+				row.Skipped += 1
+			}
+		}
+	}
+	return &rpt, nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteMarkdown writes the report to w as a markdown table, with a cell for each adapter and
+// suite, followed by a list of why each unsupported cell is unsupported, and of the first
+// failure of each cell which failed.
+func (rpt *Report) WriteMarkdown(w io.Writer) error {
+	var sb strings.Builder
+
+	sb.WriteString("| adapter |")
+	for _, suite := range rpt.Suites {
+		fmt.Fprintf(&sb, " %s |", suite)
+	}
+	sb.WriteString(" pass | fail | unsupported | skipped |\n|---|")
+	for range rpt.Suites {
+		sb.WriteString("---|")
+	}
+	sb.WriteString("---|---|---|---|\n")
+
+	var failures, unsupported []string
+	var reference bool
+	for _, row := range rpt.Rows {
+		fmt.Fprintf(&sb, "| %s |", row.Adapter)
+		for _, suite := range rpt.Suites {
+			rc, ok := row.cell(suite)
+			switch {
+			case !ok:
+				sb.WriteString(" |")
+			case rc.Outcome == OutcomeFail:
+				fmt.Fprintf(&sb, " fail (%d/%d%s) |", rc.Failed, rc.Tests, rc.notes())
+				failures = append(failures,
+					fmt.Sprintf("- %s/%s: %s\n", row.Adapter, suite, markdownCode(rc.Message)))
+			case rc.Outcome == OutcomePass:
+				fmt.Fprintf(&sb, " pass (%d%s) |", rc.Tests, rc.notes())
+			case rc.Outcome == OutcomeSkipped:
+				fmt.Fprintf(&sb, " skipped (%d) |", rc.Skipped)
+			default:
+				fmt.Fprintf(&sb, " %s |", rc.Outcome)
+				unsupported = append(unsupported,
+					fmt.Sprintf("- %s/%s: %s\n", row.Adapter, suite, markdownCode(rc.Message)))
+			}
+			reference = reference || (ok && rc.Reference)
+		}
+		fmt.Fprintf(&sb, " %d | %d | %d | %d |\n", row.Pass, row.Fail, row.Unsupported,
+			row.Skipped)
+	}
+
+	if reference {
+		sb.WriteString("\nA reference cell ran without another adapter to compare against.\n")
+	}
+	if len(unsupported) > 0 {
+		sb.WriteString("\nUnsupported:\n\n")
+		for _, u := range unsupported {
+			sb.WriteString(u)
+		}
+	}
+	if len(failures) > 0 {
+		sb.WriteString("\nFirst failures:\n\n")
+		for _, f := range failures {
+			sb.WriteString(f)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// notes returns the number of tests which were skipped, and whether the adapter was the
+// reference, to follow the number of tests in a markdown cell.
+func (rc ReportCell) notes() string {
+	var s string
+	if rc.Skipped > 0 {
+		s += fmt.Sprintf(", %d skipped", rc.Skipped)
+	}
+	if rc.Reference {
+		s += ", reference"
+	}
+	return s
+}
+
+func (row ReportRow) cell(suite string) (ReportCell, bool) {
+	for _, rc := range row.Cells {
+		if rc.Suite == suite {
+			return rc, true
+		}
+	}
+	return ReportCell{}, false
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	} else if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return "`" + strings.ReplaceAll(s, "`", "'") + "`"
+}
+
+// WriteJSON writes the report to w as indented JSON.
+func (rpt *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rpt)
+}
@@ -0,0 +1,99 @@
+package trietest
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// NodeSource returns the encoded node with hash. Serialize only encodes the root node of a
+// trie: any node whose encoding is 32 bytes or longer is referenced by its hash, so
+// deserializing needs a source for those nodes.
+type NodeSource func(hash []byte) ([]byte, error)
+
+// ProofNodes returns a NodeSource for the nodes in proofs; the proofs of all of the keys in
+// a trie contain all of its nodes.
+func ProofNodes(proofs ...[][]byte) NodeSource {
+	nodes := map[string][]byte{}
+	for _, proof := range proofs {
+		for _, enc := range proof {
+			nodes[string(keccak256(enc))] = enc
+		}
+	}
+	return mapNodes(nodes)
+}
+
+// mapNodes returns a NodeSource for nodes, which are by hash.
+func mapNodes(nodes map[string][]byte) NodeSource {
+	return func(hash []byte) ([]byte, error) {
+		enc, ok := nodes[string(hash)]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return enc, nil
+	}
+}
+
+// deserialize puts the key/value pairs of the serialized trie in b, which is the output of
+// Serialize, into t, getting nodes referenced by hash from ns.
+func deserialize(t Trie, b []byte, ns NodeSource) (Trie, error) {
+	if !bytes.Equal(b, []byte{0x80}) {
+		root, err := decodeNode(b)
+		if err != nil {
+			return nil, err
+		}
+		err = putNodes(t, root, nil, ns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if h := keccak256(b); !bytes.Equal(t.Hash(), h) {
+		return nil, fmt.Errorf("trietest: deserialized hash %x does not match %x", t.Hash(), h)
+	}
+	return t, nil
+}
+
+func putNodes(t Trie, n node, path []byte, ns NodeSource) error {
+	switch n := n.(type) {
+	case nil:
+		return nil
+	case hashNode:
+		enc, err := ns(n)
+		if err != nil {
+			return fmt.Errorf("trietest: node %x: %w", []byte(n), err)
+		}
+		if h := keccak256(enc); !bytes.Equal(h, n) {
+			return fmt.Errorf("trietest: node %x has hash %x", []byte(n), h)
+		}
+
+		nn, err := decodeNode(enc)
+		if err != nil {
+			return err
+		}
+		return putNodes(t, nn, path, ns)
+	case *branchNode:
+		for idx, child := range n.children {
+			err := putNodes(t, child, joinPath(path, []byte{byte(idx)}), ns)
+			if err != nil {
+				return err
+			}
+		}
+		if n.value != nil {
+			return putValue(t, path, n.value)
+		}
+		return nil
+	case *extensionNode:
+		return putNodes(t, n.next, joinPath(path, n.path), ns)
+	case *leafNode:
+		return putValue(t, joinPath(path, n.path), n.value)
+	}
+
+	panic(fmt.Sprintf("trietest: unexpected node: %T", n))
+}
+
+func putValue(t Trie, path, val []byte) error {
+	if len(path)%2 == 1 {
+		return fmt.Errorf("%w: value at odd length path %x", errInvalidNode, path)
+	}
+	return t.Put(nibblesToKey(path), val)
+}
@@ -0,0 +1,160 @@
+package trietest_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/leftmike/trietest"
+)
+
+// testProofNodes returns the nodes on the paths to the keys of kv, which must be all of the
+// keys in tries, from the first of tries which supports Prove or, if none do, from an oracle
+// trie with kv. It is the external NodeSource that Deserialize needs for the nodes that
+// Serialize references by hash; for a trie without CapProve, such as mptrie, the nodes are
+// not its own.
+func testProofNodes(t *testing.T, tries []trietest.NamedTrie, kv []keyValue) trietest.NodeSource {
+	t.Helper()
+
+	var prover trietest.NamedTrie
+	for _, nt := range tries {
+		if nt.Trie.Capabilities().Has(trietest.CapProve) {
+			prover = nt
+			break
+		}
+	}
+	if prover.Trie == nil {
+		prover = trietest.NamedTrie{Name: "oracle", Trie: trietest.NewOracleTrie()}
+		for _, kv := range kv {
+			testPutTrie(t, prover.Name, prover.Trie, kv.k, kv.v)
+		}
+	}
+
+	var proofs [][][]byte
+	for _, kv := range kv {
+		proof, err := prover.Trie.Prove(kv.k)
+		if err != nil {
+			t.Errorf("%s.Prove(%#v) failed with %s", prover.Name, kv.k, err)
+		}
+		proofs = append(proofs, proof)
+	}
+	return trietest.ProofNodes(proofs...)
+}
+
+func testDeserializeTrie(t *testing.T, who string, trie trietest.Trie,
+	deserialize func(b []byte, ns trietest.NodeSource) (trietest.Trie, error),
+	ns trietest.NodeSource, skv []keyValue) {
+
+	t.Helper()
+
+	s, err := trie.Serialize()
+	if err != nil {
+		t.Errorf("%s.Serialize() failed with %s", who, err)
+		return
+	}
+
+	dt, err := deserialize(s, ns)
+	if err != nil {
+		t.Errorf("Deserialize(%s.Serialize()) failed with %s", who, err)
+		return
+	}
+
+	testHashTrie(t, who, dt, trie.Hash())
+	for _, kv := range skv {
+		testGetTrie(t, who, dt, kv.k, kv.v)
+	}
+	testIterateTrie(t, who, dt, nil, nil, skv)
+}
+
+func testDeserialize(t *testing.T, who string, newTrie func() trietest.Trie,
+	deserialize func(b []byte, ns trietest.NodeSource) (trietest.Trie, error)) {
+
+	t.Helper()
+
+	trie := newTrie()
+	testDeserializeTrie(t, who, trie, deserialize, trietest.ProofNodes(), nil)
+
+	kv := []keyValue{
+		{k: []byte{0x00, 0x12, 0x34}, v: []byte{0x01, 0x23, 0x45}},
+		{k: []byte{0xA0, 0x12, 0x34}, v: []byte{0xA1, 0x23, 0x45}},
+	}
+	for _, kv := range kv {
+		testPutTrie(t, who, trie, kv.k, kv.v)
+	}
+	testDeserializeTrie(t, who, trie, deserialize, trietest.ProofNodes(), kv)
+
+	kv = sortKeyValues(randomKeyValues(1, 100, 1, 64, 1, 128))
+	trie = newTrie()
+	for _, kv := range kv {
+		testPutTrie(t, who, trie, kv.k, kv.v)
+	}
+	ns := testProofNodes(t, []trietest.NamedTrie{{Name: who, Trie: trie}}, kv)
+	testDeserializeTrie(t, who, trie, deserialize, ns, kv)
+
+	s, _ := trie.Serialize()
+	_, err := deserialize(s, trietest.ProofNodes())
+	if !errors.Is(err, trietest.ErrNotFound) {
+		t.Errorf("Deserialize(%s.Serialize()) without nodes returned %v, expected not found",
+			who, err)
+	}
+
+	_, err = deserialize(s,
+		func(hash []byte) ([]byte, error) {
+			b, err := ns(hash)
+			if err != nil {
+				return nil, err
+			}
+			return append(append([]byte(nil), b[:len(b)-1]...), b[len(b)-1]^0xFF), nil
+		})
+	if err == nil {
+		t.Errorf("Deserialize(%s.Serialize()) with modified nodes did not fail", who)
+	}
+}
+
+// testSerializeTries checks that all of the tries which can serialize produce the same
+// bytes, and that a serialized root of 32 bytes or longer hashes to the hash of the trie.
+func testSerializeTries(t *testing.T, tries []trietest.NamedTrie) {
+	t.Helper()
+
+	var ref string
+	var want []byte
+	for _, nt := range tries {
+		if !nt.Trie.Capabilities().Has(trietest.CapSerialize) {
+			continue
+		}
+
+		s, err := nt.Trie.Serialize()
+		if err != nil {
+			t.Errorf("%s.Serialize() failed with %s", nt.Name, err)
+			continue
+		}
+		if len(s) >= 32 {
+			if h := crypto.Keccak256(s); !bytes.Equal(h, nt.Trie.Hash()) {
+				t.Errorf("%s.Serialize(): hash of root node: got %x, %s.Hash(): %x", nt.Name, h,
+					nt.Name, nt.Trie.Hash())
+			}
+		}
+
+		if want == nil {
+			ref = nt.Name
+			want = s
+		} else if !bytes.Equal(s, want) {
+			t.Errorf("%s.Serialize(): got\n%s%s.Serialize():\n%s", nt.Name,
+				testFormatSerialized(s), ref, testFormatSerialized(want))
+		}
+	}
+}
+
+func TestDeserialize(t *testing.T) {
+	suiteDeserialize(t, testAdapters(t, trietest.CapSerialize))
+}
+
+func suiteDeserialize(t *testing.T, tas []trietest.Adapter) {
+	for _, ta := range tas {
+		if ta.Deserialize != nil {
+			testDeserialize(t, ta.Name, ta.New, ta.Deserialize)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package trietest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// diverges returns true if ops make new tries from adapters disagree.
+func diverges(ops []Op, adapters []Adapter) bool {
+	tries := make([]NamedTrie, 0, len(adapters))
+	for _, a := range adapters {
+		tries = append(tries, NamedTrie{Name: a.Name, Trie: a.New()})
+	}
+
+	var d *Divergence
+	return errors.As(Lockstep(ops, tries...), &d)
+}
+
+// Shrink returns a smaller sequence of ops which still makes new tries from adapters
+// disagree. It drops ops (delta debugging), shortens keys and values, and merges keys until
+// none of these keep the disagreement. It fails if ops do not make the tries disagree.
+func Shrink(ops []Op, adapters ...Adapter) ([]Op, error) {
+	if !diverges(ops, adapters) {
+		return nil, errors.New("trietest: ops do not diverge")
+	}
+
+	ops = append([]Op(nil), ops...)
+	for {
+		n := len(ops)
+		ops = dropOps(ops, adapters)
+		shorter := shortenOps(ops, adapters)
+		merged := mergeKeys(ops, adapters)
+		if len(ops) == n && !shorter && !merged {
+			return ops, nil
+		}
+	}
+}
+
+// dropOps removes chunks of ops, starting with halves and ending with single ops, as long as
+// the rest still diverge.
+func dropOps(ops []Op, adapters []Adapter) []Op {
+	for chunk := len(ops) / 2; chunk > 0; chunk /= 2 {
+		for start := 0; start < len(ops); {
+			end := start + chunk
+			if end > len(ops) {
+				end = len(ops)
+			}
+
+			rest := append(append([]Op(nil), ops[:start]...), ops[end:]...)
+			if diverges(rest, adapters) {
+				ops = rest
+			} else {
+				start = end
+			}
+		}
+	}
+	return ops
+}
+
+// shortenOps tries to shorten the key and the value of each op. It returns true if it made
+// any of them shorter.
+func shortenOps(ops []Op, adapters []Adapter) bool {
+	var shorter bool
+	for i := range ops {
+		for _, f := range []*[]byte{&ops[i].Key, &ops[i].Value} {
+			for len(*f) > 0 {
+				b := *f
+				var ok bool
+				for _, s := range [][]byte{b[:len(b)/2], b[:len(b)-1], b[1:]} {
+					if ops[i].Kind == OpPut && f == &ops[i].Value && len(s) == 0 {
+						continue
+					}
+					*f = s
+					if diverges(ops, adapters) {
+						ok = true
+						break
+					}
+					*f = b
+				}
+				if !ok {
+					break
+				}
+				shorter = true
+			}
+		}
+	}
+	return shorter
+}
+
+// mergeKeys tries to replace every use of one key with another key. It returns true if it
+// merged any keys.
+func mergeKeys(ops []Op, adapters []Adapter) bool {
+	var keys [][]byte
+	for _, op := range ops {
+		if op.Key == nil {
+			continue
+		}
+		dup := false
+		for _, k := range keys {
+			if bytes.Equal(k, op.Key) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			keys = append(keys, op.Key)
+		}
+	}
+
+	var merged bool
+	for i := len(keys) - 1; i > 0; i -= 1 {
+		for j := 0; j < i; j += 1 {
+			try := append([]Op(nil), ops...)
+			for k := range try {
+				if bytes.Equal(try[k].Key, keys[i]) {
+					try[k].Key = keys[j]
+				}
+			}
+			if diverges(try, adapters) {
+				copy(ops, try)
+				merged = true
+				break
+			}
+		}
+	}
+	return merged
+}
+
+// BasicTestEntry formats ops as a test named name, in the format of testdata/basic.json, so
+// that it can be added to the tests there; the expected results are those of a new trie from
+// ref.
+func BasicTestEntry(name string, ops []Op, ref Factory) string {
+	var sb strings.Builder
+	t := ref()
+
+	step := func(fields ...string) {
+		sb.WriteString("\t\t\t\t{")
+		for i := 0; i < len(fields); i += 2 {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "%q: %s", fields[i], fields[i+1])
+		}
+		sb.WriteString("},\n")
+	}
+	hex := func(b []byte) string {
+		return fmt.Sprintf("\"%x\"", b)
+	}
+
+	fmt.Fprintf(&sb, "\t\t{\n\t\t\t\"name\": %q,\n\t\t\t\"steps\": [\n", name)
+	for _, op := range ops {
+		r := Apply(t, op)
+		kind := fmt.Sprintf("%q", op.Kind.String())
+		switch op.Kind {
+		case OpDelete, OpGet:
+			if errors.Is(r.Err, ErrNotFound) {
+				step("op", kind, "key", hex(op.Key), "notFound", "true")
+			} else if op.Kind == OpGet {
+				step("op", kind, "key", hex(op.Key), "value", hex(r.Value))
+			} else {
+				step("op", kind, "key", hex(op.Key))
+			}
+		case OpHash:
+			step("op", kind, "hash", hex(r.Value))
+		case OpPut:
+			step("op", kind, "key", hex(op.Key), "value", hex(op.Value))
+		case OpSerialize:
+			if r.Err == nil {
+				step("op", kind, "serialized", hex(r.Value))
+			}
+		}
+	}
+	if len(ops) == 0 || ops[len(ops)-1].Kind != OpHash {
+		step("op", `"hash"`, "hash", hex(t.Hash()))
+	}
+
+	// The last step has no trailing comma.
+	entry := strings.TrimSuffix(sb.String(), ",\n")
+	return entry + "\n\t\t\t]\n\t\t}"
+}
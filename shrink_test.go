@@ -0,0 +1,71 @@
+package trietest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+// longKeyTrie drops the Put of any key longer than three bytes.
+type longKeyTrie struct {
+	trietest.Trie
+}
+
+func (lkt longKeyTrie) Put(key, val []byte) error {
+	if len(key) > 3 {
+		return nil
+	}
+	return lkt.Trie.Put(key, val)
+}
+
+func TestShrink(t *testing.T) {
+	// The buggy trie only diverges after a put if its hash is compared after every op.
+	tas := testAdapters(t, trietest.CapDelete|trietest.CapIncrementalHash)
+	buggy := trietest.Adapter{
+		Name: "buggy",
+		New: func() trietest.Trie {
+			return longKeyTrie{tas[0].New()}
+		},
+	}
+
+	tw := testWorkload{
+		put: 4, update: 2, delete: 2, get: 2, hash: 1,
+		minKeys: 0, maxKeys: 50,
+		minKey: 1, maxKey: 3,
+		minVal: 1, maxVal: 40,
+	}
+	ops := tw.ops(1, 200)
+	_, err := trietest.Shrink(ops, tas[0], buggy)
+	if err == nil {
+		t.Errorf("Shrink() of ops which do not diverge did not fail")
+	}
+
+	ops = append(ops,
+		trietest.Op{
+			Kind:  trietest.OpPut,
+			Key:   []byte{0x01, 0x23, 0x45, 0x67, 0x89},
+			Value: []byte{0x01, 0x02, 0x03},
+		})
+	ops = append(ops, tw.ops(2, 100)...)
+	shrunk, err := trietest.Shrink(ops, tas[0], buggy)
+	if err != nil {
+		t.Fatalf("Shrink() failed with %s", err)
+	}
+	if len(shrunk) != 1 || shrunk[0].Kind != trietest.OpPut || len(shrunk[0].Key) != 4 ||
+		len(shrunk[0].Value) != 1 {
+
+		t.Errorf("Shrink(): got %v, want a single put of a four byte key", shrunk)
+	}
+
+	entry := trietest.BasicTestEntry("shrunk", shrunk, tas[0].New)
+	ntcs := parseTestFile(t, "BasicTestEntry()",
+		[]byte(fmt.Sprintf(`{"version": 1, "tests": [%s]}`, entry)))
+	if len(ntcs) != 1 || ntcs[0].name != "shrunk" || len(ntcs[0].cases) != 2 ||
+		ntcs[0].cases[0].op != testPut || ntcs[0].cases[1].op != testHash {
+
+		t.Errorf("BasicTestEntry(): got %s", entry)
+	} else {
+		testTrie(t, tas[0].Name, tas[0].New(), ntcs[0].cases)
+	}
+}
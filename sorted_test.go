@@ -0,0 +1,56 @@
+package trietest_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+// sortedKeyValues returns kv sorted by key, without the keys which have the key before them as
+// a prefix, so that they can be put in order into a trie without CapRandomAccess.
+func sortedKeyValues(kv []keyValue) []keyValue {
+	var skv []keyValue
+	for _, kv := range sortKeyValues(kv) {
+		if len(skv) > 0 && bytes.HasPrefix(kv.k, skv[len(skv)-1].k) {
+			continue
+		}
+		skv = append(skv, kv)
+	}
+	return skv
+}
+
+// testSorted puts sorted keys from kg into a trie for each of tas, and then checks that the
+// tries have the same hash.
+func testSorted(t *testing.T, tas []trietest.Adapter, seed int64, n int, kg keyGenerator) {
+	t.Helper()
+
+	skv := sortedKeyValues(generatedKeyValues(seed, n, kg, 1, 128))
+	ops := make([]trietest.Op, 0, len(skv)+1)
+	for _, kv := range skv {
+		ops = append(ops, trietest.Op{Kind: trietest.OpPut, Key: kv.k, Value: kv.v})
+	}
+
+	run := newTestRun(t, seed, tas)
+	defer run.done()
+	run.lockstep(append(ops, trietest.Op{Kind: trietest.OpHash}))
+}
+
+func TestSorted(t *testing.T) {
+	suiteSorted(t, selectAdapters(t, 0))
+}
+
+func suiteSorted(t *testing.T, tas []trietest.Adapter) {
+	tries := testTries(tas)
+	for _, nt := range tries[1:] {
+		testHashTries(t, tries[0], nt)
+	}
+
+	testRandom(t, []int{1, 20, 200, 2000, 20000},
+		func(seed int64, n int) {
+			testKeys(t, n,
+				func(kg keyGenerator) {
+					testSorted(t, tas, seed, n, kg)
+				})
+		})
+}
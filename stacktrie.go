@@ -0,0 +1,76 @@
+package trietest
+
+import (
+	"bytes"
+
+	ethtrie "github.com/ethereum/go-ethereum/trie"
+)
+
+// stackTrie is go-ethereum's StackTrie, which builds a trie from keys put in order, hashing
+// each subtree as soon as a later key shows that it is complete. It has no Get, Iterate, or
+// Delete, and hashing it finishes it, so it does not have CapRandomAccess.
+type stackTrie struct {
+	trie *ethtrie.StackTrie
+	last []byte // the key of the last Put; nil until the first Put
+	hash []byte // nil until the trie has been hashed
+}
+
+func NewStackTrie() Trie {
+	return &stackTrie{
+		trie: ethtrie.NewStackTrie(nil),
+	}
+}
+
+func (_ *stackTrie) Capabilities() Capabilities {
+	return 0
+}
+
+func (_ *stackTrie) Delete(key []byte) error {
+	return ErrNotSupported
+}
+
+func (_ *stackTrie) Get(key []byte) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+func (st *stackTrie) Hash() []byte {
+	if st.hash == nil {
+		h := st.trie.Hash()
+		st.hash = h[:]
+	}
+	return st.hash
+}
+
+func (_ *stackTrie) Iterate(start, prefix []byte) Iterator {
+	return &keySetIterator{err: ErrNotSupported}
+}
+
+func (_ *stackTrie) Prove(key []byte) ([][]byte, error) {
+	return nil, ErrNotSupported
+}
+
+// Put returns ErrNotSupported for a put that the StackTrie would panic on: a key which does
+// not come after the key of the last Put, or has it as a prefix, an empty value, or any put
+// after the trie has been hashed. The StackTrie hashes its leaves in place, so it is given a
+// copy of val.
+func (st *stackTrie) Put(key, val []byte) error {
+	if st.hash != nil || len(val) == 0 {
+		return ErrNotSupported
+	} else if st.last != nil &&
+		(bytes.Compare(key, st.last) <= 0 || bytes.HasPrefix(key, st.last)) {
+
+		return ErrNotSupported
+	}
+
+	err := st.trie.TryUpdate(key, append([]byte(nil), val...))
+	if err != nil {
+		return err
+	}
+
+	st.last = append([]byte{}, key...)
+	return nil
+}
+
+func (_ *stackTrie) Serialize() ([]byte, error) {
+	return nil, ErrNotSupported
+}
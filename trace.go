@@ -0,0 +1,158 @@
+package trietest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// TraceStep is an op along with the hash of the trie after it; a nil Hash is not checked.
+type TraceStep struct {
+	Op
+	Hash []byte
+}
+
+// Trace is a sequence of ops, starting from an empty trie, which can be written to a file and
+// replayed. The file format is one JSON object per line, with the bytes in hex:
+//
+//	{"op":"put","key":"0123","value":"45","hash":"..."}
+type Trace []TraceStep
+
+type traceLine struct {
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// RecordTrace applies ops to t, which must be empty, and returns a trace of them with the
+// hash of t after each one.
+func RecordTrace(ops []Op, t Trie) Trace {
+	tr := make(Trace, 0, len(ops))
+	for _, op := range ops {
+		Apply(t, op)
+		tr = append(tr, TraceStep{Op: op, Hash: t.Hash()})
+	}
+	return tr
+}
+
+// Ops returns the ops of the trace.
+func (tr Trace) Ops() []Op {
+	ops := make([]Op, 0, len(tr))
+	for _, ts := range tr {
+		ops = append(ops, ts.Op)
+	}
+	return ops
+}
+
+// WriteTrace writes tr to w, one step per line.
+func WriteTrace(w io.Writer, tr Trace) error {
+	enc := json.NewEncoder(w)
+	for _, ts := range tr {
+		err := enc.Encode(traceLine{
+			Op:    ts.Kind.String(),
+			Key:   hex.EncodeToString(ts.Key),
+			Value: hex.EncodeToString(ts.Value),
+			Hash:  hex.EncodeToString(ts.Hash),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadTrace reads a trace written by WriteTrace from r; blank lines are skipped.
+func ReadTrace(r io.Reader) (Trace, error) {
+	var tr Trace
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1<<24)
+	for ln := 1; scan.Scan(); ln += 1 {
+		b := bytes.TrimSpace(scan.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		var tl traceLine
+		err := json.Unmarshal(b, &tl)
+		if err != nil {
+			return nil, fmt.Errorf("trietest: trace line %d: %s", ln, err)
+		}
+		ts, err := tl.step()
+		if err != nil {
+			return nil, fmt.Errorf("trietest: trace line %d: %s", ln, err)
+		}
+		tr = append(tr, ts)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+func (tl traceLine) step() (TraceStep, error) {
+	var ts TraceStep
+	ts.Kind = -1
+	for ok, name := range opKindNames {
+		if name == tl.Op {
+			ts.Kind = OpKind(ok)
+		}
+	}
+	if ts.Kind < 0 {
+		return ts, fmt.Errorf("unknown op: %s", tl.Op)
+	}
+
+	var err error
+	for _, f := range []struct {
+		s string
+		b *[]byte
+	}{
+		{tl.Key, &ts.Key},
+		{tl.Value, &ts.Value},
+		{tl.Hash, &ts.Hash},
+	} {
+		*f.b, err = hex.DecodeString(f.s)
+		if err != nil {
+			return ts, err
+		} else if len(*f.b) == 0 {
+			*f.b = nil
+		}
+	}
+	return ts, nil
+}
+
+// Replay applies the ops of tr to tries, which must be empty, in lockstep as Lockstep does,
+// and also checks the hash of the reference trie after each op against the hash in the
+// trace. It returns a *Divergence for the first disagreement; a disagreement with the trace
+// has a Ref of "trace".
+func Replay(tr Trace, tries ...NamedTrie) error {
+	err := checkTries(tr.Ops(), tries)
+	if err != nil {
+		return err
+	}
+
+	for idx, ts := range tr {
+		err = lockstep(idx, ts.Op, tries)
+		if err != nil {
+			return err
+		}
+
+		if ts.Hash != nil {
+			if h := tries[0].Trie.Hash(); !bytes.Equal(h, ts.Hash) {
+				return &Divergence{
+					Index: idx,
+					Op:    ts.Op,
+					After: true,
+					Ref:   "trace",
+					Want:  Result{Value: ts.Hash},
+					Name:  tries[0].Name,
+					Got:   Result{Value: h},
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package trietest_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leftmike/trietest"
+)
+
+func testReplay(t *testing.T, who string, tr trietest.Trace) {
+	t.Helper()
+
+	var caps trietest.Capabilities
+	for _, ts := range tr {
+		if ts.Kind == trietest.OpDelete {
+			caps |= trietest.CapDelete
+		}
+	}
+
+	err := trietest.Replay(tr, testTries(testAdapters(t, caps))...)
+	if err != nil {
+		t.Errorf("Replay(%s) failed with %s", who, err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	tw := testWorkload{
+		put: 4, update: 2, delete: 3, get: 2, hash: 1,
+		minKeys: 0, maxKeys: 8,
+		minKey: 0, maxKey: 3,
+		minVal: 1, maxVal: 40,
+	}
+	ops := append(tw.ops(1, 200), trietest.Op{Kind: trietest.OpSerialize})
+	tas := testAdapters(t, trietest.CapDelete)
+	tr := trietest.RecordTrace(ops, tas[0].New())
+
+	var buf bytes.Buffer
+	err := trietest.WriteTrace(&buf, tr)
+	if err != nil {
+		t.Fatalf("WriteTrace() failed with %s", err)
+	}
+	rtr, err := trietest.ReadTrace(&buf)
+	if err != nil {
+		t.Fatalf("ReadTrace() failed with %s", err)
+	}
+	if len(rtr) != len(tr) {
+		t.Fatalf("ReadTrace(): got %d steps, want %d", len(rtr), len(tr))
+	}
+	for i := range tr {
+		if tr[i].Kind != rtr[i].Kind || !bytes.Equal(tr[i].Key, rtr[i].Key) ||
+			!bytes.Equal(tr[i].Value, rtr[i].Value) || !bytes.Equal(tr[i].Hash, rtr[i].Hash) {
+
+			t.Errorf("ReadTrace()[%d]: got %s %x, want %s %x", i, rtr[i].Op, rtr[i].Hash,
+				tr[i].Op, tr[i].Hash)
+		}
+	}
+
+	testReplay(t, "trace", rtr)
+
+	rtr[len(rtr)/2].Hash = bytes.Repeat([]byte{0xFF}, 32)
+	err = trietest.Replay(rtr, testTries(tas)...)
+	var d *trietest.Divergence
+	if !errors.As(err, &d) {
+		t.Errorf("Replay() with modified hash returned %v, expected divergence", err)
+	} else if d.Index != len(rtr)/2 || d.Ref != "trace" {
+		t.Errorf("Replay() with modified hash: got divergence %s", d)
+	}
+
+	for _, s := range []string{
+		`{"op":"bad"}`,
+		`{"op":"put","key":"0g"}`,
+		`{"op":"put"`,
+	} {
+		_, err = trietest.ReadTrace(bytes.NewBufferString(s))
+		if err == nil {
+			t.Errorf("ReadTrace(%s) did not fail", s)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "traces", "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := trietest.ReadTrace(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("ReadTrace(%s) failed with %s", path, err)
+			continue
+		}
+
+		testReplay(t, path, tr)
+	}
+}
@@ -2,17 +2,99 @@ package trietest
 
 import (
 	"errors"
+	"strings"
 )
 
 var (
 	ErrNotFound     = errors.New("trietest: not found")
 	ErrNotSupported = errors.New("trietest: not supported")
+	ErrInvalidProof = errors.New("trietest: invalid proof")
 )
 
 type Trie interface {
+	Capabilities() Capabilities
 	Delete(key []byte) error
 	Get(key []byte) ([]byte, error)
 	Hash() []byte
+	Iterate(start, prefix []byte) Iterator
+	// Prove returns the encoded nodes on the path to key, which VerifyProof checks against
+	// Hash(). If key is not in the trie, the nodes prove that it is absent. Only a trie with
+	// CapProve supports Prove.
+	Prove(key []byte) ([][]byte, error)
+	// Put with an empty val deletes key, as in go-ethereum, where a key can not have an empty
+	// value; it is not an error if key is not in the trie. A trie without CapDelete returns
+	// ErrNotSupported instead.
 	Put(key, val []byte) error
-	Serialize() ([]byte, bool)
+	Serialize() ([]byte, error)
+}
+
+// Capabilities are the optional operations and features that a Trie supports; a Trie returns
+// ErrNotSupported from an operation that it does not support.
+type Capabilities uint
+
+const (
+	CapDelete Capabilities = 1 << iota
+	CapSerialize
+	// CapProve is for a trie which can prove keys. The mptrie adapter does not have it, since
+	// its library does not expose its nodes; so proofs are only compared between the others.
+	CapProve
+	// CapIncrementalHash is for a trie whose Hash takes time in proportion to the changes
+	// since it was last hashed, rather than to its size, so it can be hashed after every op.
+	CapIncrementalHash
+	// CapRandomAccess is for a trie which supports Get and Iterate, and a Put of any key at any
+	// time. A trie without it, such as a StackTrie, only supports a Put of a key which comes
+	// after the key of the previous Put and does not have it as a prefix, and a Hash once all
+	// of the keys have been put.
+	CapRandomAccess
+	// CapIterate is for a trie whose Iterate walks the trie itself. The mptrie and zhang
+	// adapters do not have it: their libraries can not iterate, so they iterate over the keys
+	// which have been put into them, getting each value with Get. Their Iterate is left in
+	// place for ProveRange, but it is not compared against the other tries.
+	CapIterate
+)
+
+var capabilityNames = []struct {
+	c    Capabilities
+	name string
+}{
+	{CapDelete, "delete"},
+	{CapIncrementalHash, "incremental-hash"},
+	{CapIterate, "iterate"},
+	{CapProve, "prove"},
+	{CapRandomAccess, "random-access"},
+	{CapSerialize, "serialize"},
+}
+
+func (c Capabilities) Has(o Capabilities) bool {
+	return c&o == o
+}
+
+func (c Capabilities) String() string {
+	var names []string
+	for _, cn := range capabilityNames {
+		if c.Has(cn.c) {
+			names = append(names, cn.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Iterator returns the key/value pairs of a trie in key order. Iterate positions it before
+// the first key that is greater than or equal to start and has prefix; either may be nil.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Err() error
+}
+
+// ignoreNotFound is for a Put with an empty value, which deletes a key that need not be there.
+func ignoreNotFound(err error) error {
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
 }
@@ -3,8 +3,16 @@ package trietest_test
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,6 +29,99 @@ const (
 	testSerialize
 )
 
+var (
+	adaptersFlag = flag.String("trietest.adapters", "",
+		"comma separated list of the registered adapters to test; all of them by default")
+	tracesFlag = flag.String("trietest.traces", os.TempDir(),
+		"directory to write traces of the ops of failed tests to")
+	seedFlag = flag.Int64("trietest.seed", 0,
+		"seed for the random tests, which then run one iteration; a new seed for each "+
+			"iteration by default")
+	durationFlag = flag.Duration("trietest.duration", time.Minute,
+		"how long to run each random test for, unless -short or -trietest.seed")
+	sizesFlag = flag.String("trietest.sizes", "",
+		"comma separated list of sizes for the random tests, instead of their own sizes: "+
+			"the number of ops for TestRandom, and the number of keys for the others")
+)
+
+// testRandom calls test with a new seed for each of sizes, repeating until -trietest.duration
+// is up, or only once if -short. With -trietest.seed, it calls test once for each of sizes
+// with that seed. The seed and size of each call that fails are logged.
+func testRandom(t *testing.T, sizes []int, test func(seed int64, n int)) {
+	t.Helper()
+
+	if *sizesFlag != "" {
+		sizes = nil
+		for _, s := range strings.Split(*sizesFlag, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || n < 1 {
+				t.Fatalf("-trietest.sizes: %s is not a valid size", s)
+			}
+			sizes = append(sizes, n)
+		}
+	}
+
+	start := time.Now()
+	for {
+		for _, n := range sizes {
+			seed := *seedFlag
+			if seed == 0 {
+				seed = time.Now().UnixNano()
+			}
+
+			failed := t.Failed()
+			test(seed, n)
+			if !failed && t.Failed() {
+				t.Logf("failed with seed %d and size %d: rerun with -trietest.seed=%d "+
+					"-trietest.sizes=%d", seed, n, seed, n)
+			}
+		}
+
+		if testing.Short() || *seedFlag != 0 || time.Since(start) > *durationFlag {
+			break
+		}
+	}
+}
+
+// testAdapters returns the registered adapters selected by -trietest.adapters which have caps
+// and CapRandomAccess. The first one is the reference that the others are compared against.
+// It skips the test if no adapters are left.
+func testAdapters(t *testing.T, caps trietest.Capabilities) []trietest.Adapter {
+	t.Helper()
+
+	return selectAdapters(t, caps|trietest.CapRandomAccess)
+}
+
+// selectAdapters is testAdapters without CapRandomAccess, for the tests which also run the
+// adapters without it.
+func selectAdapters(t *testing.T, caps trietest.Capabilities) []trietest.Adapter {
+	t.Helper()
+
+	var tas []trietest.Adapter
+	if *adaptersFlag == "" {
+		tas = trietest.Adapters()
+	} else {
+		for _, name := range strings.Split(*adaptersFlag, ",") {
+			ta, ok := trietest.LookupAdapter(strings.TrimSpace(name))
+			if !ok {
+				t.Fatalf("-trietest.adapters: %s is not a registered adapter", name)
+			}
+			tas = append(tas, ta)
+		}
+	}
+
+	var with []trietest.Adapter
+	for _, ta := range tas {
+		if ta.New().Capabilities().Has(caps) {
+			with = append(with, ta)
+		}
+	}
+	if len(with) == 0 {
+		t.Skipf("no adapters with capabilities %s", caps)
+	}
+	return with
+}
+
 type testCase struct {
 	op         testOp
 	k, v, h, s []byte
@@ -34,10 +135,17 @@ func testTrie(t *testing.T, who string, trie trietest.Trie, cases []testCase) {
 		switch c.op {
 		case testDelete:
 			err := trie.Delete(c.k)
-			if c.notFound {
+			if !trie.Capabilities().Has(trietest.CapDelete) {
+				if err != trietest.ErrNotSupported {
+					t.Errorf("%s.Delete(%v) returned %v, expected not supported", who, c.k, err)
+				}
+			} else if c.notFound {
 				if err != trietest.ErrNotFound {
 					t.Errorf("%s.Delete(%v) returned %v, expected not found", who, c.k, err)
 				}
+				if trie.Capabilities().Has(trietest.CapProve) {
+					testProveTrie(t, who, trie, c.k, nil)
+				}
 			} else if err != nil {
 				t.Errorf("%s.Delete(%v) failed with %s", who, c.k, err)
 			}
@@ -48,6 +156,9 @@ func testTrie(t *testing.T, who string, trie trietest.Trie, cases []testCase) {
 				if err != trietest.ErrNotFound {
 					t.Errorf("%s.Get(%#v) returned %v, expected not found", who, c.k, err)
 				}
+				if trie.Capabilities().Has(trietest.CapProve) {
+					testProveTrie(t, who, trie, c.k, nil)
+				}
 			} else if err != nil {
 				t.Errorf("%s.Get(%#v) failed with %s", who, c.k, err)
 			} else if !bytes.Equal(c.v, v) {
@@ -67,11 +178,16 @@ func testTrie(t *testing.T, who string, trie trietest.Trie, cases []testCase) {
 			}
 
 		case testSerialize:
-			s, ok := trie.Serialize()
-			if ok {
-				if !bytes.Equal(s, c.s) {
-					t.Errorf("%s.Serialize(): got %#v, want %#v", who, s, c.s)
+			s, err := trie.Serialize()
+			if !trie.Capabilities().Has(trietest.CapSerialize) {
+				if err != trietest.ErrNotSupported {
+					t.Errorf("%s.Serialize() returned %v, expected not supported", who, err)
 				}
+			} else if err != nil {
+				t.Errorf("%s.Serialize() failed with %s", who, err)
+			} else if !bytes.Equal(s, c.s) {
+				t.Errorf("%s.Serialize(): got\n%swant\n%s", who, testFormatSerialized(s),
+					testFormatSerialized(c.s))
 			}
 
 		default:
@@ -80,255 +196,119 @@ func testTrie(t *testing.T, who string, trie trietest.Trie, cases []testCase) {
 	}
 }
 
-func testBasic(t *testing.T, who string, newTrie func() trietest.Trie) {
+// testFile is a versioned file of named sequences of test cases, in testdata; the keys,
+// values, hashes, and serializations are in hex:
+//
+//	{"version":1,"tests":[{"name":"...","steps":[{"op":"put","key":"0123","value":"45"}]}]}
+type testFile struct {
+	Version int `json:"version"`
+	Tests   []struct {
+		Name  string     `json:"name"`
+		Steps []testStep `json:"steps"`
+	} `json:"tests"`
+}
+
+type testStep struct {
+	Op         string `json:"op"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	Hash       string `json:"hash"`
+	Serialized string `json:"serialized"`
+	NotFound   bool   `json:"notFound"`
+}
+
+const testFileVersion = 1
+
+var testOps = map[string]testOp{
+	"delete":    testDelete,
+	"get":       testGet,
+	"hash":      testHash,
+	"put":       testPut,
+	"serialize": testSerialize,
+}
+
+type namedTestCases struct {
+	name  string
+	cases []testCase
+}
+
+func readTestFile(t *testing.T, name string) []namedTestCases {
 	t.Helper()
 
-	testTrie(t, who, newTrie(),
-		[]testCase{
-			{
-				op: testPut,
-				k:  []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"),
-				v:  []byte("0123456789012345678901234567890123456789"),
-			},
-			{
-				op: testHash,
-				h: []byte{0xa3, 0x20, 0xa, 0x22, 0x8c, 0x3f, 0xfd, 0xaf, 0x4c, 0x2a, 0x76, 0xb7,
-					0x22, 0xfb, 0x81, 0x9c, 0xae, 0x1, 0x9d, 0xe2, 0xd3, 0x88, 0xac, 0x9f, 0x8f,
-					0xc4, 0x4d, 0x56, 0x55, 0xba, 0x61, 0x2d},
-			},
-			{
-				op: testSerialize,
-				s: []byte{0xf8, 0x5f, 0xb5, 0x20, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
-					0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0x73, 0x74, 0x75,
-					0x76, 0x77, 0x78, 0x79, 0x7a, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
-					0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f, 0x50, 0x51, 0x52, 0x53, 0x54, 0x55,
-					0x56, 0x57, 0x58, 0x59, 0x5a, 0xa8, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36,
-					0x37, 0x38, 0x39, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
-					0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x30, 0x31, 0x32,
-					0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39},
-			},
-		})
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseTestFile(t, name, b)
+}
 
-	key1 := []byte{0x00, 0x12, 0x34}
-	val1 := []byte{0x01, 0x23, 0x45}
-	key2 := []byte{0xA0, 0x12, 0x34}
-	val2 := []byte{0xA1, 0x23, 0x45}
-
-	testTrie(t, who, newTrie(),
-		[]testCase{
-			{
-				op: testHash,
-				h: []byte{0x56, 0xe8, 0x1f, 0x17, 0x1b, 0xcc, 0x55, 0xa6, 0xff, 0x83, 0x45, 0xe6,
-					0x92, 0xc0, 0xf8, 0x6e, 0x5b, 0x48, 0xe0, 0x1b, 0x99, 0x6c, 0xad, 0xc0, 0x1,
-					0x62, 0x2f, 0xb5, 0xe3, 0x63, 0xb4, 0x21},
-			},
-			{op: testGet, k: key1, notFound: true},
-			{op: testPut, k: key1, v: val1},
-			{op: testGet, k: key1, v: val1},
-			{
-				op: testHash,
-				h: []byte{0x72, 0x94, 0xf6, 0x2, 0x63, 0xb8, 0x94, 0xf, 0x7a, 0x61, 0x11, 0x12,
-					0x25, 0x42, 0x7a, 0xfc, 0x2f, 0xe2, 0xe8, 0x11, 0x13, 0x2a, 0xaa, 0x50, 0x86,
-					0xcd, 0x9a, 0xa1, 0x27, 0xa6, 0x72, 0xe},
-			},
-			{
-				op: testSerialize,
-				s:  []byte{0xc9, 0x84, 0x20, 0x0, 0x12, 0x34, 0x83, 0x1, 0x23, 0x45},
-			},
-			{op: testGet, k: key2, notFound: true},
-			{op: testPut, k: key2, v: val2},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key2, v: val2},
-			{
-				op: testHash,
-				h: []byte{0xe0, 0x17, 0x26, 0x94, 0x41, 0xb7, 0x76, 0x97, 0xa3, 0x2f, 0x0, 0x62,
-					0xdc, 0x3f, 0x7a, 0xaa, 0xa9, 0x7, 0x1, 0x6c, 0x64, 0x2a, 0x8e, 0x82, 0xae,
-					0x28, 0xc1, 0x72, 0x59, 0xb2, 0x3e, 0xc7},
-			},
-			{
-				op: testSerialize,
-				s: []byte{0xe1, 0xc8, 0x83, 0x30, 0x12, 0x34, 0x83, 0x1, 0x23, 0x45, 0x80, 0x80,
-					0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0xc8, 0x83, 0x30, 0x12, 0x34, 0x83,
-					0xa1, 0x23, 0x45, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80},
-			},
-		})
+func parseTestFile(t *testing.T, name string, b []byte) []namedTestCases {
+	t.Helper()
 
-	key3 := []byte{0x00, 0x23, 0x45}
-	val3 := []byte{0x01, 0x01, 0x01}
-
-	testTrie(t, who, newTrie(),
-		[]testCase{
-			{op: testGet, k: key1, notFound: true},
-			{op: testPut, k: key1, v: val1},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key3, notFound: true},
-			{op: testPut, k: key3, v: val3},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key3, v: val3},
-			{
-				op: testHash,
-				h: []byte{0x48, 0x30, 0xba, 0x5a, 0x8c, 0x0, 0xae, 0x34, 0xeb, 0x70, 0xe8, 0x90,
-					0x30, 0xa4, 0x91, 0x84, 0x28, 0xea, 0x11, 0xba, 0x49, 0x88, 0xc3, 0xa8, 0x4a,
-					0xf4, 0x30, 0xee, 0xaa, 0xb1, 0xca, 0xfc},
-			},
-		})
+	var tf testFile
+	err := json.Unmarshal(b, &tf)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	} else if tf.Version != testFileVersion {
+		t.Fatalf("%s: got version %d, want %d", name, tf.Version, testFileVersion)
+	}
 
-	key4 := []byte{0x00, 0x34, 0x56}
-	val4 := []byte{0x02, 0x03, 0x04}
-
-	testTrie(t, who, newTrie(),
-		[]testCase{
-			{op: testGet, k: key1, notFound: true},
-			{op: testPut, k: key1, v: val1},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key2, notFound: true},
-			{op: testPut, k: key2, v: val2},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key2, v: val2},
-			{op: testGet, k: key4, notFound: true},
-			{op: testPut, k: key4, v: val4},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key2, v: val2},
-			{op: testGet, k: key4, v: val4},
-			{
-				op: testHash,
-				h: []byte{0x30, 0x7c, 0x7f, 0x43, 0xab, 0x18, 0x12, 0xa6, 0x78, 0x57, 0x45, 0x26,
-					0xf2, 0x5f, 0xb1, 0xa6, 0x11, 0xf0, 0xb3, 0x8e, 0xa5, 0xc, 0x59, 0x7d, 0x3a,
-					0x44, 0x77, 0x6b, 0x8e, 0x7e, 0x60, 0x13},
-			},
-		})
+	var ntcs []namedTestCases
+	for _, test := range tf.Tests {
+		ntc := namedTestCases{name: test.Name}
+		for i, ts := range test.Steps {
+			op, ok := testOps[ts.Op]
+			if !ok {
+				t.Fatalf("%s: %s[%d]: unknown op: %s", name, test.Name, i, ts.Op)
+			}
 
-	key5 := []byte{0x00}
-	val5 := []byte{0x11, 0x22, 0x33, 0x44}
-
-	testTrie(t, who, newTrie(),
-		[]testCase{
-			{op: testGet, k: key1, notFound: true},
-			{op: testPut, k: key1, v: val1},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key3, notFound: true},
-			{op: testPut, k: key3, v: val3},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key3, v: val3},
-			{op: testGet, k: key4, notFound: true},
-			{op: testPut, k: key4, v: val4},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key3, v: val3},
-			{op: testGet, k: key4, v: val4},
-			{op: testGet, k: key5, notFound: true},
-			{op: testPut, k: key5, v: val5},
-			{op: testGet, k: key1, v: val1},
-			{op: testGet, k: key3, v: val3},
-			{op: testGet, k: key4, v: val4},
-			{op: testGet, k: key5, v: val5},
-			{op: testGet, k: []byte{0x01}, notFound: true},
-			{
-				op: testHash,
-				h: []byte{0xdc, 0xda, 0x5e, 0x3, 0x35, 0xa0, 0xcd, 0x7f, 0xf, 0x78, 0xe0, 0x8f,
-					0x66, 0x53, 0xe7, 0x65, 0x27, 0xd, 0xf1, 0xc9, 0x81, 0xaf, 0x89, 0xf3, 0xc2,
-					0x0, 0xb5, 0x43, 0x79, 0xfe, 0x2e, 0x1a},
-			},
-		})
+			c := testCase{op: op, notFound: ts.NotFound}
+			for _, f := range []struct {
+				s string
+				b *[]byte
+			}{
+				{ts.Key, &c.k},
+				{ts.Value, &c.v},
+				{ts.Hash, &c.h},
+				{ts.Serialized, &c.s},
+			} {
+				*f.b, err = hex.DecodeString(f.s)
+				if err != nil {
+					t.Fatalf("%s: %s[%d]: %s", name, test.Name, i, err)
+				}
+			}
+			ntc.cases = append(ntc.cases, c)
+		}
+		ntcs = append(ntcs, ntc)
+	}
+	return ntcs
+}
 
-	key6 := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0x00}
-	val6 := []byte{0x66, 0x66}
-	key7 := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0x01}
-	val7 := []byte{0x77, 0x77}
-	key8 := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0x10}
-	val8 := []byte{0x88, 0x88}
-	key9 := []byte{0x01, 0x23, 0x45, 0x00}
-	val9 := []byte{0x99, 0x99}
-	val9a := []byte{0x9a, 0x9A}
-
-	testTrie(t, who, newTrie(),
-		[]testCase{
-			{op: testGet, k: key6, notFound: true},
-			{op: testPut, k: key6, v: val6},
-			{op: testGet, k: key6, v: val6},
-			{op: testGet, k: key7, notFound: true},
-			{op: testPut, k: key7, v: val7},
-			{op: testGet, k: key6, v: val6},
-			{op: testGet, k: key7, v: val7},
-			{op: testGet, k: key8, notFound: true},
-			{op: testPut, k: key8, v: val8},
-			{op: testGet, k: key6, v: val6},
-			{op: testGet, k: key7, v: val7},
-			{op: testGet, k: key8, v: val8},
-			{op: testGet, k: key9, notFound: true},
-			{op: testPut, k: key9, v: val9},
-			{op: testGet, k: key6, v: val6},
-			{op: testGet, k: key7, v: val7},
-			{op: testGet, k: key8, v: val8},
-			{op: testGet, k: key9, v: val9},
-			{op: testPut, k: key9, v: val9a},
-			{op: testGet, k: key6, v: val6},
-			{op: testGet, k: key7, v: val7},
-			{op: testGet, k: key8, v: val8},
-			{op: testGet, k: key9, v: val9a},
-			{
-				op: testHash,
-				h: []byte{0x9d, 0xf, 0xef, 0xbb, 0xce, 0x5a, 0xe7, 0x63, 0xbe, 0x29, 0x77, 0xf0,
-					0x81, 0x80, 0xf, 0x22, 0x9e, 0xa8, 0x4e, 0x4c, 0xcf, 0xe0, 0x6d, 0xb7, 0x81,
-					0xfb, 0x62, 0xf0, 0x28, 0x19, 0xa4, 0x96},
-			},
-		})
+// testFormatSerialized pretty prints b, which is the output of Serialize.
+func testFormatSerialized(b []byte) string {
+	n, err := trietest.DecodeNode(b, nil)
+	if err != nil {
+		return fmt.Sprintf("%x: %s\n", b, err)
+	}
+	return n.String()
+}
 
-	key10 := []byte{0x01, 0x23, 0x45, 0x67, 0x89}
-	val10 := []byte{0xAA, 0xAA}
-	key11 := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0x01}
-	val11 := []byte{0xBB, 0xBB}
-	key12 := []byte{0x01, 0x23, 0x45, 0x67}
-	val12 := []byte{0xCC, 0xCC}
-
-	testTrie(t, who, newTrie(),
-		[]testCase{
-			{op: testGet, k: key10, notFound: true},
-			{op: testPut, k: key10, v: val10},
-			{op: testGet, k: key10, v: val10},
-			{op: testGet, k: key11, notFound: true},
-			{op: testPut, k: key11, v: val11},
-			{op: testGet, k: key10, v: val10},
-			{op: testGet, k: key11, v: val11},
-			{op: testGet, k: key12, notFound: true},
-			{op: testPut, k: key12, v: val12},
-			{op: testGet, k: key10, v: val10},
-			{op: testGet, k: key11, v: val11},
-			{op: testGet, k: key12, v: val12},
-			{
-				op: testHash,
-				h: []byte{0xc, 0x1a, 0xc5, 0xc7, 0x84, 0x7d, 0xce, 0x2a, 0x26, 0x15, 0x71, 0xef,
-					0x3a, 0x2a, 0xe2, 0x36, 0xa4, 0xe5, 0x7e, 0xad, 0x6b, 0xc9, 0x88, 0xa1, 0xae,
-					0x3d, 0xc7, 0x35, 0x1, 0xfa, 0x28, 0x4c},
-			},
-		})
+func testBasic(t *testing.T, who string, newTrie func() trietest.Trie) {
+	t.Helper()
 
-	testTrie(t, who, newTrie(),
-		[]testCase{
-			{op: testGet, k: key10, notFound: true},
-			{op: testPut, k: key10, v: val10},
-			{op: testGet, k: key10, v: val10},
-			{op: testGet, k: key12, notFound: true},
-			{op: testPut, k: key12, v: val12},
-			{op: testGet, k: key10, v: val10},
-			{op: testGet, k: key12, v: val12},
-			{op: testGet, k: key11, notFound: true},
-			{op: testPut, k: key11, v: val11},
-			{op: testGet, k: key10, v: val10},
-			{op: testGet, k: key11, v: val11},
-			{op: testGet, k: key12, v: val12},
-			{
-				op: testHash,
-				h: []byte{0xc, 0x1a, 0xc5, 0xc7, 0x84, 0x7d, 0xce, 0x2a, 0x26, 0x15, 0x71, 0xef,
-					0x3a, 0x2a, 0xe2, 0x36, 0xa4, 0xe5, 0x7e, 0xad, 0x6b, 0xc9, 0x88, 0xa1, 0xae,
-					0x3d, 0xc7, 0x35, 0x1, 0xfa, 0x28, 0x4c},
-			},
-		})
+	for _, ntc := range readTestFile(t, "basic.json") {
+		testTrie(t, fmt.Sprintf("%s(%s)", who, ntc.name), newTrie(), ntc.cases)
+	}
 }
 
 func TestBasic(t *testing.T) {
-	testBasic(t, "mptrie", trietest.NewMPTrie)
-	testBasic(t, "zhang", trietest.NewZhangTrie)
-	testBasic(t, "eth", trietest.NewEthTrie)
+	suiteBasic(t, testAdapters(t, 0))
+}
+
+func suiteBasic(t *testing.T, tas []trietest.Adapter) {
+	for _, ta := range tas {
+		testBasic(t, ta.Name, ta.New)
+	}
 }
 
 func testDeleteTrie(t *testing.T, who string, trie trietest.Trie, k []byte) {
@@ -360,6 +340,56 @@ func testHashTrie(t *testing.T, who string, trie trietest.Trie, hash []byte) {
 	}
 }
 
+// testCheckTrie checks the invariants of the nodes of trie, if it can export them.
+func testCheckTrie(t *testing.T, who string, trie trietest.Trie) {
+	t.Helper()
+
+	vs, err := trietest.CheckTrie(trie)
+	if errors.Is(err, trietest.ErrNotSupported) {
+		return
+	} else if err != nil {
+		t.Errorf("CheckTrie(%s) failed with %s", who, err)
+	} else if len(vs) > 0 {
+		t.Errorf("CheckTrie(%s): %d violations, the first %s", who, len(vs), vs[0])
+	}
+}
+
+// testCheckTries checks the invariants of the nodes of tries. Tries with the same hash have
+// the same nodes, so only one of them is checked for each hash: a NodeExporter if there is
+// one, since all of its nodes can be checked, rather than only its root node.
+func testCheckTries(t *testing.T, tries []trietest.NamedTrie) {
+	t.Helper()
+
+	checked := map[string]bool{}
+	for _, exporter := range []bool{true, false} {
+		for _, nt := range tries {
+			if _, ok := nt.Trie.(trietest.NodeExporter); ok != exporter {
+				continue
+			}
+			h := string(nt.Trie.Hash())
+			if !checked[h] {
+				checked[h] = true
+				testCheckTrie(t, nt.Name, nt.Trie)
+			}
+		}
+	}
+}
+
+// testHashTries checks that nt has the same hash as ref, and if not, reports the first node
+// where they differ.
+func testHashTries(t *testing.T, ref, nt trietest.NamedTrie) {
+	t.Helper()
+
+	nd, err := trietest.Diagnose(ref, nt)
+	if err != nil {
+		t.Errorf("%s.Hash(): got %x, %s: %x: %s", nt.Name, nt.Trie.Hash(), ref.Name,
+			ref.Trie.Hash(), err)
+	} else if nd != nil {
+		t.Errorf("%s.Hash(): got %x, %s: %x: %s", nt.Name, nt.Trie.Hash(), ref.Name,
+			ref.Trie.Hash(), nd)
+	}
+}
+
 func testPutTrie(t *testing.T, who string, trie trietest.Trie, k, v []byte) {
 	t.Helper()
 
@@ -369,6 +399,46 @@ func testPutTrie(t *testing.T, who string, trie trietest.Trie, k, v []byte) {
 	}
 }
 
+func iterateTrie(t *testing.T, who string, trie trietest.Trie, start, prefix []byte) []keyValue {
+	t.Helper()
+
+	var kv []keyValue
+	it := trie.Iterate(start, prefix)
+	for it.Next() {
+		kv = append(kv, keyValue{k: it.Key(), v: it.Value()})
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("%s.Iterate(%#v, %#v) failed with %s", who, start, prefix, err)
+	}
+	return kv
+}
+
+// testIterateTrie checks that iterating trie from start with prefix returns kv. A trie without
+// CapIterate is not checked, since its Iterate does not walk the trie.
+func testIterateTrie(t *testing.T, who string, trie trietest.Trie, start, prefix []byte,
+	kv []keyValue) {
+
+	t.Helper()
+
+	if !trie.Capabilities().Has(trietest.CapIterate) {
+		return
+	}
+	got := iterateTrie(t, who, trie, start, prefix)
+	if len(got) != len(kv) {
+		t.Errorf("%s.Iterate(%#v, %#v): got %d keys, want %d", who, start, prefix, len(got),
+			len(kv))
+		return
+	}
+
+	for i := range kv {
+		if !bytes.Equal(got[i].k, kv[i].k) || !bytes.Equal(got[i].v, kv[i].v) {
+			t.Errorf("%s.Iterate(%#v, %#v)[%d]: got %#v: %#v, want %#v: %#v", who, start, prefix,
+				i, got[i].k, got[i].v, kv[i].k, kv[i].v)
+			return
+		}
+	}
+}
+
 func makeKey(el int, lb []byte, ll int) []byte {
 	if (el+ll)%2 == 1 {
 		ll += 1
@@ -402,6 +472,10 @@ func trieSize(el, ll1, ll2, vl int) int {
 }
 
 func TestEdge(t *testing.T) {
+	suiteEdge(t, testAdapters(t, 0))
+}
+
+func suiteEdge(t *testing.T, tas []trietest.Adapter) {
 	for vl := 1; vl < 35; vl++ {
 		for el := 0; el < 70; el++ {
 			for ll1 := 1; ll1 < 70; ll1++ {
@@ -411,17 +485,14 @@ func TestEdge(t *testing.T) {
 						continue
 					}
 
-					trie := trietest.NewEthTrie()
-					testEdge(t, "eth", trie, el, ll1, ll2, vl)
-					hash := trie.Hash()
-
-					trie = trietest.NewMPTrie()
-					testEdge(t, "mptrie", trie, el, ll1, ll2, vl)
-					testHashTrie(t, "mptrie", trie, hash)
-
-					trie = trietest.NewZhangTrie()
-					testEdge(t, "zhang", trie, el, ll1, ll2, vl)
-					testHashTrie(t, "zhang", trie, hash)
+					tries := testTries(tas)
+					for i, nt := range tries {
+						testEdge(t, nt.Name, nt.Trie, el, ll1, ll2, vl)
+						if i > 0 {
+							testHashTries(t, tries[0], nt)
+						}
+					}
+					testSerializeTries(t, tries)
 				}
 			}
 		}
@@ -432,32 +503,23 @@ type keyValue struct {
 	k, v []byte
 }
 
-func testGetPut(t *testing.T, who string, trie trietest.Trie, seed int64, kv []keyValue) {
-	t.Helper()
-
-	r := rand.New(rand.NewSource(seed))
-	for i := range r.Perm(len(kv)) {
-		testPutTrie(t, who, trie, kv[i].k, kv[i].v)
-	}
-
-	for i := range r.Perm(len(kv)) {
-		testGetTrie(t, who, trie, kv[i].k, kv[i].v)
-	}
+func sortKeyValues(kv []keyValue) []keyValue {
+	skv := append([]keyValue(nil), kv...)
+	sort.Slice(skv,
+		func(i, j int) bool {
+			return bytes.Compare(skv[i].k, skv[j].k) < 0
+		})
+	return skv
 }
 
-func testUpdate(t *testing.T, who string, trie trietest.Trie, seed int64, kv []keyValue,
-	vs [][]byte) {
-
-	t.Helper()
-
-	r := rand.New(rand.NewSource(seed))
-	for i := range r.Perm(len(kv)) {
-		testPutTrie(t, who, trie, kv[i].k, vs[i])
-	}
-
-	for i := range r.Perm(len(kv)) {
-		testGetTrie(t, who, trie, kv[i].k, vs[i])
+func selectKeyValues(kv []keyValue, bs []bool, b bool) []keyValue {
+	var skv []keyValue
+	for i := range kv {
+		if bs[i] == b {
+			skv = append(skv, kv[i])
+		}
 	}
+	return skv
 }
 
 func randomBytes(r *rand.Rand, min, max int) []byte {
@@ -511,52 +573,234 @@ func randomValues(seed int64, n, minVal, maxVal int) [][]byte {
 	return vs
 }
 
-func testRandomGetPut(t *testing.T, seed int64, n int) {
-	t.Helper()
+// testTries returns a new trie for each of tas.
+func testTries(tas []trietest.Adapter) []trietest.NamedTrie {
+	tries := make([]trietest.NamedTrie, 0, len(tas))
+	for _, ta := range tas {
+		tries = append(tries, trietest.NamedTrie{Name: ta.Name, Trie: ta.New()})
+	}
+	return tries
+}
 
-	kv := randomKeyValues(seed, n, 1, 64, 1, 128)
+// testRun is a trie for each of the adapters under test, along with the ops applied to them
+// so far, so that a trace of the ops can be written if the test fails.
+type testRun struct {
+	t        *testing.T
+	seed     int64
+	tas      []trietest.Adapter
+	tries    []trietest.NamedTrie
+	inc      []trietest.NamedTrie // the tries with CapIncrementalHash
+	ops      []trietest.Op
+	failed   bool
+	diverged bool
+}
 
-	trie := trietest.NewEthTrie()
-	testGetPut(t, "eth", trie, seed, kv)
-	hash := trie.Hash()
+func newTestRun(t *testing.T, seed int64, tas []trietest.Adapter) *testRun {
+	tr := &testRun{
+		t:      t,
+		seed:   seed,
+		tas:    tas,
+		tries:  testTries(tas),
+		failed: t.Failed(),
+	}
+	for _, nt := range tr.tries {
+		if nt.Trie.Capabilities().Has(trietest.CapIncrementalHash) {
+			tr.inc = append(tr.inc, nt)
+		}
+	}
+	return tr
+}
 
-	trie = trietest.NewMPTrie()
-	testGetPut(t, "mptrie", trie, seed, kv)
-	testHashTrie(t, "mptrie", trie, hash)
+// lockstep applies ops to the tries in lockstep, and then checks the invariants of the nodes
+// of the tries with CapIncrementalHash, and that those which can serialize agree; done checks
+// all of the tries, since the checks hash them. The checks are run after every call, not
+// after every op, so a caller which needs them after every step, as testRandomOps does,
+// passes one op per call. It returns false if the tries diverged, broke an invariant, or
+// serialized differently.
+func (tr *testRun) lockstep(ops ...[]trietest.Op) bool {
+	tr.t.Helper()
+
+	var all []trietest.Op
+	for _, ops := range ops {
+		all = append(all, ops...)
+	}
+
+	err := trietest.Lockstep(all, tr.tries...)
+	var d *trietest.Divergence
+	if errors.As(err, &d) {
+		d.Index += len(tr.ops)
+		tr.ops = append(tr.ops, all[:d.Index-len(tr.ops)+1]...)
+		tr.diverged = true
+	} else {
+		tr.ops = append(tr.ops, all...)
+	}
+	if err != nil {
+		tr.t.Errorf("seed %d: %s", tr.seed, err)
+		if d != nil && d.After {
+			tr.diagnose(d.Name)
+		}
+		return false
+	}
 
-	trie = trietest.NewZhangTrie()
-	testGetPut(t, "zhang", trie, seed, kv)
-	testHashTrie(t, "zhang", trie, hash)
+	failed := tr.t.Failed()
+	testCheckTries(tr.t, tr.inc)
+	testSerializeTries(tr.t, tr.inc)
+	if !failed && tr.t.Failed() {
+		tr.t.Logf("seed %d: checks failed after op %d", tr.seed, len(tr.ops)-1)
+		return false
+	}
+	return true
 }
 
-func TestRandomGetPut(t *testing.T) {
-	start := time.Now()
-	for {
-		for _, n := range []int{20, 200, 2000, 20000} {
-			seed := time.Now().UnixNano()
-			testRandomGetPut(t, seed, n)
+// diagnose logs the first node where the trie named name differs from the reference trie.
+func (tr *testRun) diagnose(name string) {
+	tr.t.Helper()
+
+	for _, nt := range tr.tries[1:] {
+		if nt.Name != name {
+			continue
 		}
 
-		if testing.Short() {
-			break
+		nd, err := trietest.Diagnose(tr.tries[0], nt)
+		if err != nil {
+			tr.t.Logf("seed %d: unable to diagnose: %s", tr.seed, err)
+		} else if nd != nil {
+			tr.t.Logf("seed %d: %s", tr.seed, nd)
+		}
+	}
+}
+
+// done checks the invariants of the nodes of all of the tries, and that those which can
+// serialize agree, unless the test has failed. Then it writes a trace of the ops if the test
+// failed after the run started. The hashes in the trace are from a new trie of the first
+// adapter with CapIncrementalHash, or the reference adapter if none have it, since there is a
+// hash after every op. If the tries diverged, it also logs the shrunk ops as a test for
+// testdata/basic.json.
+func (tr *testRun) done() {
+	tr.t.Helper()
+
+	if !tr.failed && !tr.t.Failed() {
+		testCheckTries(tr.t, tr.tries)
+		testSerializeTries(tr.t, tr.tries)
+		if tr.t.Failed() {
+			tr.t.Logf("seed %d: checks failed after op %d", tr.seed, len(tr.ops)-1)
 		}
+	}
+	if tr.failed || !tr.t.Failed() {
+		return
+	}
+	tr.failed = true
 
-		if time.Since(start).Seconds() > 60 {
+	name := fmt.Sprintf("trietest-%s-%d.jsonl",
+		strings.Map(
+			func(r rune) rune {
+				if r == '/' || r == os.PathSeparator {
+					return '_'
+				}
+				return r
+			}, tr.t.Name()),
+		tr.seed)
+	path := filepath.Join(*tracesFlag, name)
+	f, err := os.Create(path)
+	if err != nil {
+		tr.t.Logf("seed %d: unable to write trace: %s", tr.seed, err)
+		return
+	}
+	defer f.Close()
+
+	rec := tr.tas[0]
+	for _, ta := range tr.tas {
+		if ta.New().Capabilities().Has(trietest.CapIncrementalHash) {
+			rec = ta
 			break
 		}
 	}
+	err = trietest.WriteTrace(f, trietest.RecordTrace(tr.ops, rec.New()))
+	if err != nil {
+		tr.t.Logf("seed %d: unable to write trace: %s", tr.seed, err)
+		return
+	}
+	tr.t.Logf("seed %d: trace of %d ops written to %s", tr.seed, len(tr.ops), path)
+
+	if tr.diverged {
+		ops, err := trietest.Shrink(tr.ops, tr.tas...)
+		if err != nil {
+			tr.t.Logf("seed %d: unable to shrink ops: %s", tr.seed, err)
+			return
+		}
+		tr.t.Logf("seed %d: shrunk to %d ops, as a test for testdata/basic.json:\n%s", tr.seed,
+			len(ops), trietest.BasicTestEntry(fmt.Sprintf("shrunk-%d", tr.seed), ops,
+				tr.tas[0].New))
+	}
+}
+
+func putOps(seed int64, kv []keyValue) []trietest.Op {
+	r := rand.New(rand.NewSource(seed))
+	ops := make([]trietest.Op, 0, len(kv)+1)
+	for _, i := range r.Perm(len(kv)) {
+		ops = append(ops, trietest.Op{Kind: trietest.OpPut, Key: kv[i].k, Value: kv[i].v})
+	}
+	return append(ops, trietest.Op{Kind: trietest.OpHash})
+}
+
+func getOps(seed int64, kv []keyValue) []trietest.Op {
+	r := rand.New(rand.NewSource(seed))
+	ops := make([]trietest.Op, 0, len(kv))
+	for _, i := range r.Perm(len(kv)) {
+		ops = append(ops, trietest.Op{Kind: trietest.OpGet, Key: kv[i].k})
+	}
+	return ops
+}
+
+func deleteOps(seed int64, kv []keyValue) []trietest.Op {
+	r := rand.New(rand.NewSource(seed))
+	ops := make([]trietest.Op, 0, len(kv)+1)
+	for _, i := range r.Perm(len(kv)) {
+		ops = append(ops, trietest.Op{Kind: trietest.OpDelete, Key: kv[i].k})
+	}
+	return append(ops, trietest.Op{Kind: trietest.OpHash})
 }
 
-func testDeleteOk(t *testing.T, who string, trie trietest.Trie, kv []keyValue, bs []bool) {
+func testRandomGetPut(t *testing.T, tas []trietest.Adapter, seed int64, n int,
+	kg keyGenerator) {
+
 	t.Helper()
 
-	for i := 0; i < len(kv); i += 1 {
-		if bs[i] {
-			testDeleteTrie(t, who, trie, kv[i].k)
+	kv := generatedKeyValues(seed, n, kg, 1, 128)
+
+	skv := sortKeyValues(kv)
+
+	run := newTestRun(t, seed, tas)
+	defer run.done()
+	tries := run.tries
+	if !run.lockstep(putOps(seed, kv), getOps(seed, kv)) {
+		return
+	}
+
+	testProofsTries(t, tries, kv)
+	ns := testProofNodes(t, tries, kv)
+	for i, nt := range tries {
+		testIterateTrie(t, nt.Name, nt.Trie, nil, nil, skv)
+		if tas[i].Deserialize != nil {
+			testDeserializeTrie(t, nt.Name, nt.Trie, tas[i].Deserialize, ns, skv)
 		}
 	}
 }
 
+func TestRandomGetPut(t *testing.T) {
+	suiteRandomGetPut(t, testAdapters(t, 0))
+}
+
+func suiteRandomGetPut(t *testing.T, tas []trietest.Adapter) {
+	testRandom(t, []int{20, 200, 2000, 20000},
+		func(seed int64, n int) {
+			testKeys(t, n,
+				func(kg keyGenerator) {
+					testRandomGetPut(t, tas, seed, n, kg)
+				})
+		})
+}
+
 func testDeleteNotFound(t *testing.T, who string, trie trietest.Trie, kv []keyValue, bs []bool) {
 	t.Helper()
 
@@ -583,19 +827,6 @@ func testGetNotFound(t *testing.T, who string, trie trietest.Trie, kv []keyValue
 	}
 }
 
-func testPutOk(t *testing.T, who string, trie trietest.Trie, kv []keyValue, bs []bool) {
-	t.Helper()
-
-	for i := 0; i < len(kv); i += 1 {
-		if bs[i] {
-			err := trie.Put(kv[i].k, kv[i].v)
-			if err != nil {
-				t.Errorf("%s.Put(%#v, %#v) failed with %s", who, kv[i].k, kv[i].v, err)
-			}
-		}
-	}
-}
-
 func randomBoolSlice(seed int64, n, t int) []bool {
 	bs := make([]bool, n)
 	for t > 0 {
@@ -611,96 +842,527 @@ func randomBoolSlice(seed int64, n, t int) []bool {
 	return bs
 }
 
-func testRandomDeleteGetPut(t *testing.T, seed int64, n int) {
+func testRandomDeleteGetPut(t *testing.T, tas []trietest.Adapter, seed int64, n int,
+	kg keyGenerator) {
+
 	t.Helper()
 
-	kv := randomKeyValues(seed, n, 1, 64, 1, 128)
+	kv := generatedKeyValues(seed, n, kg, 1, 128)
 	bs := randomBoolSlice(seed, n, n/4)
+	skv1 := sortKeyValues(kv)
+	dkv := selectKeyValues(kv, bs, true)
+	skv2 := sortKeyValues(selectKeyValues(kv, bs, false))
+	akv := absentKeyValues(dkv)
+
+	run := newTestRun(t, seed, tas)
+	defer run.done()
+	tries := run.tries
+	if !run.lockstep(putOps(seed, kv)) {
+		return
+	}
+	ref := tries[0]
+	hash1 := ref.Trie.Hash()
 
-	trie := trietest.NewEthTrie()
-	testGetPut(t, "eth", trie, seed, kv)
-	hash1 := trie.Hash()
-	testDeleteOk(t, "eth", trie, kv, bs)
-	hash2 := trie.Hash()
-	testDeleteNotFound(t, "eth", trie, kv, bs)
-	testGetNotFound(t, "eth", trie, kv, bs)
-	testHashTrie(t, "eth", trie, hash2)
-	testPutOk(t, "eth", trie, kv, bs)
-	testHashTrie(t, "eth", trie, hash1)
-
-	trie = trietest.NewMPTrie()
-	testGetPut(t, "mptrie", trie, seed, kv)
-	testHashTrie(t, "mptrie", trie, hash1)
-	testDeleteOk(t, "mptrie", trie, kv, bs)
-	testHashTrie(t, "mptrie", trie, hash2)
-	testDeleteNotFound(t, "mptrie", trie, kv, bs)
-	testGetNotFound(t, "mptrie", trie, kv, bs)
-	testHashTrie(t, "mptrie", trie, hash2)
-	testPutOk(t, "mptrie", trie, kv, bs)
-	testHashTrie(t, "mptrie", trie, hash1)
+	if !run.lockstep(deleteOps(seed, dkv)) {
+		return
+	}
+	testProofsTries(t, tries, skv2)
+	testProofsTries(t, tries, akv)
+	ns2 := testProofNodes(t, tries, skv2)
+	for i, nt := range tries {
+		hash2 := nt.Trie.Hash()
+		testDeleteNotFound(t, nt.Name, nt.Trie, kv, bs)
+		testGetNotFound(t, nt.Name, nt.Trie, kv, bs)
+		testHashTrie(t, nt.Name, nt.Trie, hash2)
+		testIterateTrie(t, nt.Name, nt.Trie, nil, nil, skv2)
+		if tas[i].Deserialize != nil {
+			testDeserializeTrie(t, nt.Name, nt.Trie, tas[i].Deserialize, ns2, skv2)
+		}
+	}
+
+	if !run.lockstep(putOps(seed, dkv)) {
+		return
+	}
+	ns1 := testProofNodes(t, tries, skv1)
+	for i, nt := range tries {
+		testHashTrie(t, nt.Name, nt.Trie, hash1)
+		testIterateTrie(t, nt.Name, nt.Trie, nil, nil, skv1)
+		if tas[i].Deserialize != nil {
+			testDeserializeTrie(t, nt.Name, nt.Trie, tas[i].Deserialize, ns1, skv1)
+		}
+	}
 }
 
 func TestRandomDeleteGetPut(t *testing.T) {
-	start := time.Now()
-	for {
-		for _, n := range []int{20, 200, 2000, 20000} {
-			seed := time.Now().UnixNano()
-			testRandomDeleteGetPut(t, seed, n)
+	suiteRandomDeleteGetPut(t, testAdapters(t, trietest.CapDelete))
+}
+
+func suiteRandomDeleteGetPut(t *testing.T, tas []trietest.Adapter) {
+	testRandom(t, []int{20, 200, 2000, 20000},
+		func(seed int64, n int) {
+			testKeys(t, n,
+				func(kg keyGenerator) {
+					testRandomDeleteGetPut(t, tas, seed, n, kg)
+				})
+		})
+}
+
+func testRandomUpdate(t *testing.T, tas []trietest.Adapter, seed int64, n int,
+	kg keyGenerator) {
+
+	t.Helper()
+
+	kv := generatedKeyValues(seed, n, kg, 1, 128)
+	vs := randomValues(seed, n, 1, 256)
+	ukv := make([]keyValue, 0, n)
+	for i := range kv {
+		ukv = append(ukv, keyValue{k: kv[i].k, v: vs[i]})
+	}
+	skv := sortKeyValues(ukv)
+
+	run := newTestRun(t, seed, tas)
+	defer run.done()
+	tries := run.tries
+	if !run.lockstep(putOps(seed, kv), putOps(seed+1, ukv), getOps(seed, ukv)) {
+		return
+	}
+
+	testProofsTries(t, tries, ukv)
+	ns := testProofNodes(t, tries, ukv)
+	for i, nt := range tries {
+		testIterateTrie(t, nt.Name, nt.Trie, nil, nil, skv)
+		if tas[i].Deserialize != nil {
+			testDeserializeTrie(t, nt.Name, nt.Trie, tas[i].Deserialize, ns, skv)
 		}
+	}
+}
 
-		if testing.Short() {
-			break
+func TestRandomUpdate(t *testing.T) {
+	suiteRandomUpdate(t, testAdapters(t, 0))
+}
+
+func suiteRandomUpdate(t *testing.T, tas []trietest.Adapter) {
+	testRandom(t, []int{20, 200, 2000, 20000},
+		func(seed int64, n int) {
+			testKeys(t, n,
+				func(kg keyGenerator) {
+					testRandomUpdate(t, tas, seed, n, kg)
+				})
+		})
+}
+
+// insertOrders returns n orders to put the keys of skv in: sorted, reverse sorted, and
+// random.
+func insertOrders(r *rand.Rand, n int, skv []keyValue) [][]trietest.Op {
+	sorted := make([]int, len(skv))
+	reverse := make([]int, len(skv))
+	for i := range skv {
+		sorted[i] = i
+		reverse[i] = len(skv) - i - 1
+	}
+
+	perms := [][]int{sorted, reverse}
+	for len(perms) < n {
+		perms = append(perms, r.Perm(len(skv)))
+	}
+
+	var orders [][]trietest.Op
+	for _, perm := range perms {
+		ops := make([]trietest.Op, 0, len(perm))
+		for _, i := range perm {
+			ops = append(ops, trietest.Op{Kind: trietest.OpPut, Key: skv[i].k, Value: skv[i].v})
 		}
+		orders = append(orders, ops)
+	}
+	return orders
+}
 
-		if time.Since(start).Seconds() > 60 {
-			break
+// deleteOrder returns a random order to insert skv in, interleaved with puts of extra keys
+// which are later deleted, and with deletes and puts again of some of the keys of skv.
+func deleteOrder(r *rand.Rand, skv, extra []keyValue) []trietest.Op {
+	var ops []trietest.Op
+	for _, i := range r.Perm(len(skv)) {
+		ops = append(ops, trietest.Op{Kind: trietest.OpPut, Key: skv[i].k, Value: skv[i].v})
+	}
+
+	insert := func(op trietest.Op, after int) int {
+		at := after + r.Intn(len(ops)-after+1)
+		ops = append(ops[:at], append([]trietest.Op{op}, ops[at:]...)...)
+		return at
+	}
+	for _, kv := range extra {
+		at := insert(trietest.Op{Kind: trietest.OpPut, Key: kv.k, Value: kv.v}, 0)
+		insert(trietest.Op{Kind: trietest.OpDelete, Key: kv.k}, at+1)
+	}
+	for _, i := range r.Perm(len(skv))[:len(skv)/4] {
+		for at := range ops {
+			if ops[at].Kind == trietest.OpPut && bytes.Equal(ops[at].Key, skv[i].k) {
+				at = insert(trietest.Op{Kind: trietest.OpDelete, Key: skv[i].k}, at+1)
+				insert(trietest.Op{Kind: trietest.OpPut, Key: skv[i].k, Value: skv[i].v},
+					at+1)
+				break
+			}
 		}
 	}
+	return ops
 }
 
-func testRandomUpdate(t *testing.T, seed int64, n int) {
+func testInsertOrder(t *testing.T, tas []trietest.Adapter, seed int64, n, minKey, maxKey int) {
 	t.Helper()
 
-	kv := randomKeyValues(seed, n, 1, 64, 1, 128)
-	vs := randomValues(seed, n, 1, 256)
+	kv := randomKeyValues(seed, n*2, minKey, maxKey, 1, 64)
+	skv := sortKeyValues(kv[:n])
+	extra := kv[n:]
+	r := rand.New(rand.NewSource(seed))
 
-	trie := trietest.NewEthTrie()
-	testGetPut(t, "eth", trie, seed, kv)
-	hash1 := trie.Hash()
-	testUpdate(t, "eth", trie, seed, kv, vs)
-	hash2 := trie.Hash()
+	orders := insertOrders(r, 10, skv)
+	var hash []byte
+	for _, ta := range tas {
+		for _, ops := range orders {
+			trie := ta.New()
+			for _, op := range ops {
+				testPutTrie(t, ta.Name, trie, op.Key, op.Value)
+			}
+
+			if hash == nil {
+				hash = trie.Hash()
+			} else if h := trie.Hash(); !bytes.Equal(h, hash) {
+				t.Errorf("seed %d: %s: hash depends on insert order: got %x, want %x", seed,
+					ta.Name, h, hash)
+				return
+			}
+		}
+	}
 
-	trie = trietest.NewMPTrie()
-	testGetPut(t, "mptrie", trie, seed, kv)
-	testHashTrie(t, "mptrie", trie, hash1)
-	testUpdate(t, "mptrie", trie, seed, kv, vs)
-	testHashTrie(t, "mptrie", trie, hash2)
+	var deletes [][]trietest.Op
+	for len(deletes) < 5 {
+		deletes = append(deletes, deleteOrder(r, skv, extra))
+	}
+	for _, ta := range tas {
+		if !ta.New().Capabilities().Has(trietest.CapDelete) {
+			continue
+		}
+		for _, ops := range deletes {
+			trie := ta.New()
+			err := trietest.Lockstep(ops, trietest.NamedTrie{Name: ta.Name, Trie: trie})
+			if err != nil {
+				t.Errorf("seed %d: %s", seed, err)
+			} else if h := trie.Hash(); !bytes.Equal(h, hash) {
+				t.Errorf("seed %d: %s: hash depends on insert and delete order: got %x, want %x",
+					seed, ta.Name, h, hash)
+				return
+			}
+		}
+	}
+}
 
-	trie = trietest.NewZhangTrie()
-	testGetPut(t, "zhang", trie, seed, kv)
-	testHashTrie(t, "zhang", trie, hash1)
-	testUpdate(t, "zhang", trie, seed, kv, vs)
-	testHashTrie(t, "zhang", trie, hash2)
+func TestInsertOrder(t *testing.T) {
+	suiteInsertOrder(t, testAdapters(t, 0))
 }
 
-func TestRandomUpdate(t *testing.T) {
-	start := time.Now()
-	for {
-		for _, n := range []int{20, 200, 2000, 20000} {
-			seed := time.Now().UnixNano()
-			testRandomUpdate(t, seed, n)
+func suiteInsertOrder(t *testing.T, tas []trietest.Adapter) {
+	testRandom(t, []int{16, 200},
+		func(seed int64, n int) {
+			for _, c := range []struct {
+				minKey, maxKey int
+			}{
+				{1, 2},
+				{1, 4},
+				{1, 64},
+				{32, 32},
+			} {
+				testInsertOrder(t, tas, seed, n, c.minKey, c.maxKey)
+			}
+		})
+}
+
+func testIterate(t *testing.T, who string, newTrie func() trietest.Trie) {
+	t.Helper()
+
+	kv := []keyValue{
+		{k: []byte{0x01}, v: []byte{0x11}},
+		{k: []byte{0x01, 0x23}, v: []byte{0x22}},
+		{k: []byte{0x01, 0x23, 0x45}, v: []byte{0x33}},
+		{k: []byte{0x01, 0x24}, v: []byte{0x44}},
+		{k: []byte{0x02}, v: []byte{0x55}},
+		{k: []byte{0x12, 0x34}, v: []byte{0x66}},
+		{k: []byte{0xA0, 0x00, 0x00, 0x00}, v: []byte{0x77}},
+	}
+
+	trie := newTrie()
+	testIterateTrie(t, who, trie, nil, nil, nil)
+	for i := len(kv) - 1; i >= 0; i -= 1 {
+		testPutTrie(t, who, trie, kv[i].k, kv[i].v)
+	}
+
+	testIterateTrie(t, who, trie, nil, nil, kv)
+	testIterateTrie(t, who, trie, []byte{0x01, 0x23}, nil, kv[1:])
+	testIterateTrie(t, who, trie, []byte{0x01, 0x23, 0x00}, nil, kv[2:])
+	testIterateTrie(t, who, trie, []byte{0x01, 0x23, 0x46}, nil, kv[3:])
+	testIterateTrie(t, who, trie, []byte{0xFF}, nil, nil)
+	testIterateTrie(t, who, trie, nil, []byte{0x01}, kv[:4])
+	testIterateTrie(t, who, trie, nil, []byte{0x01, 0x23}, kv[1:3])
+	testIterateTrie(t, who, trie, nil, []byte{0x03}, nil)
+	testIterateTrie(t, who, trie, []byte{0x01, 0x23, 0x45}, []byte{0x01}, kv[2:4])
+	testIterateTrie(t, who, trie, []byte{0x00}, []byte{0x12}, kv[5:6])
+}
+
+func TestIterate(t *testing.T) {
+	suiteIterate(t, testAdapters(t, trietest.CapIterate))
+}
+
+func suiteIterate(t *testing.T, tas []trietest.Adapter) {
+	for _, ta := range tas {
+		testIterate(t, ta.Name, ta.New)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	suiteCapabilities(t, testAdapters(t, 0))
+}
+
+func suiteCapabilities(t *testing.T, tas []trietest.Adapter) {
+	for _, ta := range tas {
+		trie := ta.New()
+		caps := trie.Capabilities()
+
+		err := trie.Delete([]byte{0x01, 0x23})
+		if caps.Has(trietest.CapDelete) {
+			if err != trietest.ErrNotFound {
+				t.Errorf("%s.Delete() returned %v, expected not found", ta.Name, err)
+			}
+		} else if err != trietest.ErrNotSupported {
+			t.Errorf("%s.Delete() returned %v, expected not supported", ta.Name, err)
 		}
 
-		if testing.Short() {
-			break
+		_, err = trie.Serialize()
+		if caps.Has(trietest.CapSerialize) {
+			if err != nil {
+				t.Errorf("%s.Serialize() failed with %s", ta.Name, err)
+			}
+		} else if err != trietest.ErrNotSupported {
+			t.Errorf("%s.Serialize() returned %v, expected not supported", ta.Name, err)
 		}
 
-		if time.Since(start).Seconds() > 60 {
-			break
+		_, err = trie.Prove([]byte{0x01, 0x23})
+		if caps.Has(trietest.CapProve) {
+			if err != nil {
+				t.Errorf("%s.Prove() failed with %s", ta.Name, err)
+			}
+		} else if err != trietest.ErrNotSupported {
+			t.Errorf("%s.Prove() returned %v, expected not supported", ta.Name, err)
+		}
+	}
+
+	for _, c := range []struct {
+		caps trietest.Capabilities
+		s    string
+	}{
+		{0, "none"},
+		{trietest.CapDelete, "delete"},
+		{trietest.CapSerialize | trietest.CapDelete, "delete,serialize"},
+		{trietest.CapSerialize | trietest.CapProve | trietest.CapDelete, "delete,prove,serialize"},
+		{trietest.CapIncrementalHash | trietest.CapDelete, "delete,incremental-hash"},
+		{trietest.CapRandomAccess | trietest.CapProve, "prove,random-access"},
+		{trietest.CapIterate | trietest.CapDelete, "delete,iterate"},
+	} {
+		if s := c.caps.String(); s != c.s {
+			t.Errorf("Capabilities(%d).String(): got %s, want %s", c.caps, s, c.s)
+		}
+	}
+}
+
+// testWorkload is a random mix of operations: the weights are the relative chances of each
+// kind of operation, and the number of keys in the trie is kept from minKeys through maxKeys.
+type testWorkload struct {
+	put, update, delete, get, hash int
+	minKeys, maxKeys               int
+	minKey, maxKey                 int
+	minVal, maxVal                 int
+}
+
+func (tw testWorkload) ops(seed int64, n int) []trietest.Op {
+	r := rand.New(rand.NewSource(seed))
+	var live [][]byte
+	index := map[string]int{}
+
+	newKey := func() []byte {
+		for {
+			k := randomBytes(r, tw.minKey, tw.maxKey)
+			if _, ok := index[string(k)]; !ok {
+				return k
+			}
+		}
+	}
+
+	ops := make([]trietest.Op, 0, n)
+	total := tw.put + tw.update + tw.delete + tw.get + tw.hash
+	for len(ops) < n {
+		w := r.Intn(total)
+		if len(live) < tw.minKeys {
+			w = 0
+		} else if w < tw.put {
+			if len(live) >= tw.maxKeys {
+				w = tw.put + tw.update
+			}
+		} else if w < tw.put+tw.update {
+			if len(live) == 0 {
+				w = 0
+			}
+		} else if w < tw.put+tw.update+tw.delete {
+			if len(live) <= tw.minKeys {
+				w = 0
+			}
+		}
+
+		var op trietest.Op
+		switch {
+		case w < tw.put:
+			op = trietest.Op{
+				Kind:  trietest.OpPut,
+				Key:   newKey(),
+				Value: randomBytes(r, tw.minVal, tw.maxVal),
+			}
+			index[string(op.Key)] = len(live)
+			live = append(live, op.Key)
+		case w < tw.put+tw.update:
+			op = trietest.Op{
+				Kind:  trietest.OpPut,
+				Key:   live[r.Intn(len(live))],
+				Value: randomBytes(r, tw.minVal, tw.maxVal),
+			}
+		case w < tw.put+tw.update+tw.delete:
+			i := r.Intn(len(live))
+			op = trietest.Op{Kind: trietest.OpDelete, Key: live[i]}
+			if i < len(live)-1 {
+				live[i] = live[len(live)-1]
+				index[string(live[i])] = i
+			}
+			live = live[:len(live)-1]
+			delete(index, string(op.Key))
+		case w < tw.put+tw.update+tw.delete+tw.get:
+			var k []byte
+			if len(live) == 0 || r.Intn(4) == 0 {
+				k = newKey()
+			} else {
+				k = live[r.Intn(len(live))]
+			}
+			op = trietest.Op{Kind: trietest.OpGet, Key: k}
+		default:
+			op = trietest.Op{Kind: trietest.OpHash}
+		}
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// testModel checks the tries against a map of their expected contents: the result of each
+// Get and Delete before it is applied to the tries, and all of the contents every so often.
+type testModel map[string][]byte
+
+func (tm testModel) check(t *testing.T, nt trietest.NamedTrie, op trietest.Op) {
+	t.Helper()
+
+	switch op.Kind {
+	case trietest.OpDelete, trietest.OpGet:
+		v, ok := tm[string(op.Key)]
+		if ok {
+			testGetTrie(t, nt.Name, nt.Trie, op.Key, v)
+		} else if _, err := nt.Trie.Get(op.Key); err != trietest.ErrNotFound {
+			t.Errorf("%s.Get(%#v) returned %v, expected not found", nt.Name, op.Key, err)
+		}
+	}
+}
+
+func (tm testModel) apply(op trietest.Op) {
+	switch op.Kind {
+	case trietest.OpDelete:
+		delete(tm, string(op.Key))
+	case trietest.OpPut:
+		if len(op.Value) == 0 {
+			delete(tm, string(op.Key))
+		} else {
+			tm[string(op.Key)] = op.Value
+		}
+	}
+}
+
+func (tm testModel) keyValues() []keyValue {
+	kv := make([]keyValue, 0, len(tm))
+	for k, v := range tm {
+		kv = append(kv, keyValue{k: []byte(k), v: v})
+	}
+	return sortKeyValues(kv)
+}
+
+func testRandomOps(t *testing.T, tas []trietest.Adapter, seed int64, n int,
+	tw testWorkload) {
+
+	t.Helper()
+
+	failed := t.Failed()
+	run := newTestRun(t, seed, tas)
+	defer run.done()
+	tries := run.tries
+	tm := testModel{}
+	for i, op := range tw.ops(seed, n) {
+		for _, nt := range tries {
+			tm.check(t, nt, op)
+		}
+		if !run.lockstep([]trietest.Op{op}) {
+			return
+		}
+		tm.apply(op)
+
+		if i%1000 == 999 || i == n-1 {
+			if (len(tm) < tw.minKeys && i >= tw.minKeys) || len(tm) > tw.maxKeys {
+				t.Errorf("seed %d: op %d: %d keys, want %d to %d", seed, i, len(tm),
+					tw.minKeys, tw.maxKeys)
+			}
+
+			skv := tm.keyValues()
+			for _, nt := range tries {
+				testIterateTrie(t, nt.Name, nt.Trie, nil, nil, skv)
+			}
+		}
+		if !failed && t.Failed() {
+			t.Logf("seed %d: failed at op %d", seed, i)
+			return
 		}
 	}
 }
 
 func TestRandom(t *testing.T) {
-	// XXX: test a random sequence of operations, keeping number of keys in some range
+	suiteRandom(t, testAdapters(t, trietest.CapDelete))
+}
+
+func suiteRandom(t *testing.T, tas []trietest.Adapter) {
+	workloads := []testWorkload{
+		{
+			put: 4, update: 2, delete: 3, get: 2, hash: 1,
+			minKeys: 0, maxKeys: 16,
+			minKey: 1, maxKey: 4,
+			minVal: 1, maxVal: 40,
+		},
+		{
+			put: 3, update: 2, delete: 3, get: 1, hash: 1,
+			minKeys: 100, maxKeys: 200,
+			minKey: 1, maxKey: 64,
+			minVal: 1, maxVal: 128,
+		},
+		{
+			put: 1, update: 1, delete: 1, get: 1, hash: 1,
+			minKeys: 500, maxKeys: 1000,
+			minKey: 32, maxKey: 32,
+			minVal: 1, maxVal: 64,
+		},
+	}
+
+	testRandom(t, []int{5000},
+		func(seed int64, n int) {
+			for _, tw := range workloads {
+				testRandomOps(t, tas, seed, n, tw)
+			}
+		})
 }
@@ -1,25 +1,75 @@
 package trietest
 
 import (
+	"bytes"
+
 	// Clone of github.com/zhangchiqing/merkle-patricia-trie
 	"github.com/leftmike/merklepatriciatrie"
 )
 
+func init() {
+	Register("zhang", NewZhangTrie)
+	RegisterDeserializer("zhang", DeserializeZhangTrie)
+}
+
+// zhangTrie emulates Delete, which the library does not have: deleted keys are removed from
+// keys, and the trie is rebuilt from the keys which are left before it is next hashed or
+// serialized. Until then, the trie still has the deleted keys, so Get checks keys first. A
+// rebuild takes time in proportion to the size of the trie, so zhangTrie does not have
+// CapIncrementalHash.
+//
+// The library does not prove keys, but its nodes are exported, so zhangTrie exports them by
+// walking them from the root, and proves keys from the exported nodes.
 type zhangTrie struct {
-	trie *merklepatriciatrie.Trie
+	trie  *merklepatriciatrie.Trie
+	keys  keySet
+	stale bool
 }
 
 func NewZhangTrie() Trie {
-	return zhangTrie{
+	return &zhangTrie{
 		trie: merklepatriciatrie.NewTrie(),
+		keys: keySet{},
 	}
 }
 
-func (_ zhangTrie) Delete(key []byte) error {
-	return ErrNotSupported
+// DeserializeZhangTrie rebuilds a trie from the output of its Serialize method.
+func DeserializeZhangTrie(b []byte, ns NodeSource) (Trie, error) {
+	return deserialize(NewZhangTrie(), b, ns)
+}
+
+func (_ *zhangTrie) Capabilities() Capabilities {
+	return CapDelete | CapProve | CapRandomAccess | CapSerialize
 }
 
-func (zt zhangTrie) Get(key []byte) ([]byte, error) {
+func (zt *zhangTrie) Delete(key []byte) error {
+	if _, ok := zt.keys[string(key)]; !ok {
+		return ErrNotFound
+	}
+
+	zt.keys.remove(key)
+	zt.stale = true
+	return nil
+}
+
+// ExportNodes returns the encoding of the root node and a source for the nodes referenced by
+// hash.
+func (zt *zhangTrie) ExportNodes() ([]byte, NodeSource, error) {
+	nodes := map[string][]byte{}
+	root, err := zt.Serialize()
+	if err != nil {
+		return nil, nil, err
+	} else if !bytes.Equal(root, []byte{0x80}) {
+		zhangNodes(zt.trie.Root(), nodes)
+	}
+	return root, mapNodes(nodes), nil
+}
+
+func (zt *zhangTrie) Get(key []byte) ([]byte, error) {
+	if _, ok := zt.keys[string(key)]; !ok {
+		return nil, ErrNotFound
+	}
+
 	val, found := zt.trie.Get(key)
 	if !found {
 		return nil, ErrNotFound
@@ -27,15 +77,70 @@ func (zt zhangTrie) Get(key []byte) ([]byte, error) {
 	return val, nil
 }
 
-func (zt zhangTrie) Hash() []byte {
+func (zt *zhangTrie) Hash() []byte {
+	zt.rebuild()
 	return zt.trie.Hash()
 }
 
-func (zt zhangTrie) Put(key, val []byte) error {
+func (zt *zhangTrie) Iterate(start, prefix []byte) Iterator {
+	return zt.keys.iterate(start, prefix, zt.Get)
+}
+
+func (zt *zhangTrie) Prove(key []byte) ([][]byte, error) {
+	root, ns, err := zt.ExportNodes()
+	if err != nil {
+		return nil, err
+	}
+	return proveExported(root, ns, key)
+}
+
+func (zt *zhangTrie) Put(key, val []byte) error {
+	if len(val) == 0 {
+		return ignoreNotFound(zt.Delete(key))
+	}
+
 	zt.trie.Put(key, val)
+	zt.keys.add(key)
 	return nil
 }
 
-func (zt zhangTrie) Serialize() ([]byte, bool) {
-	return zt.trie.Root().Serialize(), true
+func (zt *zhangTrie) Serialize() ([]byte, error) {
+	if bytes.Equal(zt.Hash(), emptyRoot) {
+		return []byte{0x80}, nil
+	}
+	return zt.trie.Root().Serialize(), nil
+}
+
+// rebuild replaces the trie, if any keys have been deleted from it, with a new one which has
+// only the keys which are left.
+func (zt *zhangTrie) rebuild() {
+	if !zt.stale {
+		return
+	}
+
+	trie := merklepatriciatrie.NewTrie()
+	for k := range zt.keys {
+		val, _ := zt.trie.Get([]byte(k))
+		trie.Put([]byte(k), val)
+	}
+	zt.trie = trie
+	zt.stale = false
+}
+
+// zhangNodes adds n, if it is referenced by hash, and the nodes below it which are, to nodes.
+func zhangNodes(n merklepatriciatrie.Node, nodes map[string][]byte) {
+	if enc := n.Serialize(); len(enc) >= 32 {
+		nodes[string(keccak256(enc))] = enc
+	}
+
+	switch nn := n.(type) {
+	case *merklepatriciatrie.BranchNode:
+		for _, child := range nn.Branches {
+			if child != nil {
+				zhangNodes(child, nodes)
+			}
+		}
+	case *merklepatriciatrie.ExtensionNode:
+		zhangNodes(nn.Next, nodes)
+	}
 }